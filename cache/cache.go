@@ -8,104 +8,239 @@ import (
 	humanize "github.com/dustin/go-humanize"
 )
 
-type Key = string
+// A cache of values with a byte budget. When an Update takes the cache over its capacity, items
+// chosen by the Policy are evicted until it fits again. Safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	mu       sync.Mutex
+	filled   int64
+	capacity int64
+	policy   Policy[K]
+	items    map[K]Item[K, V]
+	onEvict  func(Item[K, V])
+}
 
-type Cache struct {
-	mu     sync.Mutex
-	filled int64
-	Policy Policy
-	Items  map[Key]ItemMeta
+type Item[K comparable, V any] struct {
+	Key   K
+	Value V
+	Size  int64
+	// Pinned items are never evicted, but still count towards the filled bytes.
+	Pinned bool
 }
 
-type ItemMeta struct {
-	Size     int64
-	CanEvict bool
-	Usage
+type Opts[K comparable, V any] struct {
+	// The byte budget. Zero means unlimited.
+	Capacity int64
+	// Returns the eviction policy. The default is NewLruPolicy.
+	Policy func() Policy[K]
+	// Called with each evicted item, after the cache lock is released. It's not called for items
+	// that are removed or replaced explicitly.
+	OnEvict func(Item[K, V])
 }
 
-type Item struct {
-	Key
-	ItemMeta
+func New[K comparable, V any](opts Opts[K, V]) *Cache[K, V] {
+	newPolicy := opts.Policy
+	if newPolicy == nil {
+		newPolicy = func() Policy[K] { return NewLruPolicy[K]() }
+	}
+	return &Cache[K, V]{
+		capacity: opts.Capacity,
+		policy:   newPolicy(),
+		items:    make(map[K]Item[K, V]),
+		onEvict:  opts.OnEvict,
+	}
 }
 
-func (me *Cache) Remove(k Key) {
+// Returns the value for k, and records the access with the policy.
+func (me *Cache[K, V]) Get(k K) (v V, ok bool) {
 	me.mu.Lock()
-	i := me.Items[k]
-	me.filled -= i.Size
-	delete(me.Items, k)
-	me.Policy.Forget(k)
-	me.mu.Unlock()
+	defer me.mu.Unlock()
+	i, ok := me.items[k]
+	if !ok {
+		if a, isAdmitter := me.policy.(Admitter[K]); isAdmitter {
+			a.Record(k)
+		}
+		return
+	}
+	if !i.Pinned {
+		me.policy.Update(k)
+	}
+	return i.Value, true
 }
 
-func (me *Cache) Update(i Item) {
+// Returns the item for k without affecting its eviction order.
+func (me *Cache[K, V]) Peek(k K) (i Item[K, V], ok bool) {
 	me.mu.Lock()
-	m := me.Items[i.Key]
-	me.filled -= m.Size
-	me.filled += i.Size
-	if me.Items == nil {
-		me.Items = make(map[Key]ItemMeta)
+	defer me.mu.Unlock()
+	i, ok = me.items[k]
+	return
+}
+
+// Adds or replaces an item, and evicts others if the cache is then over capacity. The item itself
+// may be evicted if it doesn't fit, or not added at all if the policy doesn't admit it, in which
+// case false is returned.
+func (me *Cache[K, V]) Update(i Item[K, V]) (admitted bool) {
+	me.mu.Lock()
+	_, admitted = me.update(i, me.capacity)
+	evicted := me.trim()
+	me.mu.Unlock()
+	me.notifyEvicted(evicted)
+	return
+}
+
+// Returns the change in filled bytes. New items that would take filled over capacity are subject
+// to the policy's admission filter, if it has one.
+func (me *Cache[K, V]) update(i Item[K, V], capacity int64) (delta int64, admitted bool) {
+	old, exists := me.items[i.Key]
+	delta = i.Size - old.Size
+	if !exists && !i.Pinned && capacity > 0 && me.filled+delta > capacity && !me.admit(i.Key) {
+		return 0, false
 	}
-	me.Items[i.Key] = i.ItemMeta
-	if i.CanEvict {
-		me.Policy.Update(i.Key, i.Usage)
+	admitted = true
+	me.filled += delta
+	me.items[i.Key] = i
+	if i.Pinned {
+		me.policy.Forget(i.Key)
 	} else {
-		me.Policy.Forget(i.Key)
+		me.policy.Update(i.Key)
+	}
+	return
+}
+
+func (me *Cache[K, V]) admit(k K) bool {
+	a, ok := me.policy.(Admitter[K])
+	if !ok {
+		return true
+	}
+	victim, ok := me.policy.Candidate()
+	if !ok || a.Admit(k, victim) {
+		return true
+	}
+	// Rejected keys still count towards their later admission.
+	a.Record(k)
+	return false
+}
+
+func (me *Cache[K, V]) Remove(k K) (i Item[K, V], ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	i, ok = me.items[k]
+	if !ok {
+		return
+	}
+	me.remove(k)
+	return
+}
+
+func (me *Cache[K, V]) remove(k K) {
+	me.filled -= me.items[k].Size
+	delete(me.items, k)
+	me.policy.Forget(k)
+}
+
+// Evicts items until the cache is within capacity, or nothing more can be evicted.
+func (me *Cache[K, V]) trim() (evicted []Item[K, V]) {
+	for me.capacity > 0 && me.filled > me.capacity {
+		i, ok := me.evictOne()
+		if !ok {
+			break
+		}
+		evicted = append(evicted, i)
 	}
+	return
+}
+
+func (me *Cache[K, V]) evictOne() (i Item[K, V], ok bool) {
+	k, ok := me.policy.Candidate()
+	if !ok {
+		return
+	}
+	i = me.items[k]
+	me.remove(k)
+	return
+}
+
+func (me *Cache[K, V]) notifyEvicted(evicted []Item[K, V]) {
+	if me.onEvict == nil {
+		return
+	}
+	for _, i := range evicted {
+		me.onEvict(i)
+	}
+}
+
+func (me *Cache[K, V]) Capacity() int64 {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.capacity
+}
+
+// Changes the byte budget, evicting items if the cache no longer fits.
+func (me *Cache[K, V]) SetCapacity(capacity int64) {
+	me.mu.Lock()
+	me.capacity = capacity
+	evicted := me.trim()
 	me.mu.Unlock()
+	me.notifyEvicted(evicted)
 }
 
-func (me *Cache) logState() {
+func (me *Cache[K, V]) logState() {
 	log.Print(me)
 }
 
-func (me *Cache) String() string {
+func (me *Cache[K, V]) String() string {
 	me.mu.Lock()
 	defer me.mu.Unlock()
 	return fmt.Sprintf(
-		"%p: %d items, %v bytes used, lru: %s",
-		me, len(me.Items), humanize.Bytes(uint64(me.filled)),
+		"%p: %d items, %v bytes used, candidate: %s",
+		me, len(me.items), humanize.Bytes(uint64(me.filled)),
 		func() string {
-			k, ok := me.Policy.Candidate()
+			k, ok := me.policy.Candidate()
 			if ok {
-				i := me.Items[k]
-				return fmt.Sprintf(
-					"%q (%v: %v)",
-					k, humanize.Bytes(uint64(i.Size)), i.Usage)
+				i := me.items[k]
+				return fmt.Sprintf("%v (%v)", k, humanize.Bytes(uint64(i.Size)))
 			}
 			return "none"
 		}(),
 	)
 }
 
-func (me *Cache) Used() int64 {
-	return me.filled
+func (me *Cache[K, V]) Used() int64 {
+	return me.Filled()
+}
+
+func (me *Cache[K, V]) NumItems() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return len(me.items)
 }
 
-func (me *Cache) NumItems() int {
-	return len(me.Items)
+func (me *Cache[K, V]) Clear() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.clear()
 }
 
-func (me *Cache) Clear() {
-	for k := range me.Items {
-		delete(me.Items, k)
-		me.Policy.Forget(k)
+func (me *Cache[K, V]) clear() {
+	for k := range me.items {
+		me.policy.Forget(k)
 	}
-	me.Items = nil
+	clear(me.items)
 	me.filled = 0
 }
 
-func (me *Cache) Filled() int64 {
+func (me *Cache[K, V]) Filled() int64 {
 	me.mu.Lock()
 	defer me.mu.Unlock()
 	return me.filled
 }
 
-func (me *Cache) Candidate() (Item, bool) {
+// Returns the item that would be evicted next.
+func (me *Cache[K, V]) Candidate() (i Item[K, V], ok bool) {
 	me.mu.Lock()
 	defer me.mu.Unlock()
-	k, ok := me.Policy.Candidate()
-	return Item{
-		Key:      k,
-		ItemMeta: me.Items[k],
-	}, ok
+	k, ok := me.policy.Candidate()
+	if ok {
+		i = me.items[k]
+	}
+	return
 }
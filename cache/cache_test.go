@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheEvictsOnUpdate(t *testing.T) {
+	var evicted []string
+	c := New(Opts[string, int]{
+		Capacity: 10,
+		OnEvict: func(i Item[string, int]) {
+			evicted = append(evicted, i.Key)
+		},
+	})
+	c.Update(Item[string, int]{Key: "a", Value: 1, Size: 4})
+	c.Update(Item[string, int]{Key: "b", Value: 2, Size: 4})
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	c.Update(Item[string, int]{Key: "c", Value: 3, Size: 4})
+	assert.Equal(t, []string{"b"}, evicted)
+	assert.EqualValues(t, 8, c.Filled())
+	assert.Equal(t, 2, c.NumItems())
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+}
+
+func TestCachePinned(t *testing.T) {
+	c := New(Opts[string, struct{}]{Capacity: 5})
+	c.Update(Item[string, struct{}]{Key: "a", Size: 4, Pinned: true})
+	c.Update(Item[string, struct{}]{Key: "b", Size: 4})
+	_, ok := c.Peek("a")
+	assert.True(t, ok)
+	_, ok = c.Peek("b")
+	assert.False(t, ok)
+	// Nothing is evictable, so the cache stays over capacity.
+	c.Update(Item[string, struct{}]{Key: "c", Size: 2, Pinned: true})
+	assert.EqualValues(t, 6, c.Filled())
+	_, ok = c.Candidate()
+	assert.False(t, ok)
+}
+
+func TestCacheReplaceAndRemove(t *testing.T) {
+	c := New(Opts[int, string]{})
+	c.Update(Item[int, string]{Key: 1, Value: "a", Size: 3})
+	c.Update(Item[int, string]{Key: 1, Value: "b", Size: 5})
+	assert.EqualValues(t, 5, c.Filled())
+	i, ok := c.Remove(1)
+	assert.True(t, ok)
+	assert.Equal(t, "b", i.Value)
+	assert.EqualValues(t, 0, c.Filled())
+	_, ok = c.Remove(1)
+	assert.False(t, ok)
+}
+
+func TestCacheSetCapacity(t *testing.T) {
+	c := New(Opts[int, int]{Policy: func() Policy[int] { return NewFifoPolicy[int]() }})
+	for i := range 5 {
+		c.Update(Item[int, int]{Key: i, Size: 1})
+	}
+	c.Get(0)
+	c.SetCapacity(2)
+	assert.Equal(t, 2, c.NumItems())
+	_, ok := c.Peek(0)
+	assert.False(t, ok)
+	_, ok = c.Peek(4)
+	assert.True(t, ok)
+}
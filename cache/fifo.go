@@ -0,0 +1,46 @@
+package cache
+
+import "container/list"
+
+// Evicts keys in the order they were added. Accesses don't affect the order.
+type FifoPolicy[K comparable] struct {
+	// Front is newest.
+	order list.List
+	elems map[K]*list.Element
+}
+
+var _ Policy[string] = (*FifoPolicy[string])(nil)
+
+func NewFifoPolicy[K comparable]() *FifoPolicy[K] {
+	return &FifoPolicy[K]{
+		elems: make(map[K]*list.Element),
+	}
+}
+
+func (me *FifoPolicy[K]) Candidate() (k K, ok bool) {
+	e := me.order.Back()
+	if e == nil {
+		return
+	}
+	return e.Value.(K), true
+}
+
+func (me *FifoPolicy[K]) Update(k K) {
+	if _, ok := me.elems[k]; ok {
+		return
+	}
+	me.elems[k] = me.order.PushFront(k)
+}
+
+func (me *FifoPolicy[K]) Forget(k K) {
+	e, ok := me.elems[k]
+	if !ok {
+		return
+	}
+	me.order.Remove(e)
+	delete(me.elems, k)
+}
+
+func (me *FifoPolicy[K]) NumItems() int {
+	return len(me.elems)
+}
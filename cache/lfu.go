@@ -0,0 +1,90 @@
+package cache
+
+import "container/heap"
+
+// Evicts the least frequently used key. Ties go to the least recently used.
+type LfuPolicy[K comparable] struct {
+	h     lfuHeap[K]
+	elems map[K]*lfuEntry[K]
+	// Incremented on every access, to order ties.
+	clock uint64
+}
+
+var _ Policy[string] = (*LfuPolicy[string])(nil)
+
+func NewLfuPolicy[K comparable]() *LfuPolicy[K] {
+	return &LfuPolicy[K]{
+		elems: make(map[K]*lfuEntry[K]),
+	}
+}
+
+type lfuEntry[K comparable] struct {
+	key   K
+	hits  uint64
+	last  uint64
+	index int
+}
+
+type lfuHeap[K comparable] []*lfuEntry[K]
+
+func (me lfuHeap[K]) Len() int { return len(me) }
+
+func (me lfuHeap[K]) Less(i, j int) bool {
+	if me[i].hits != me[j].hits {
+		return me[i].hits < me[j].hits
+	}
+	return me[i].last < me[j].last
+}
+
+func (me lfuHeap[K]) Swap(i, j int) {
+	me[i], me[j] = me[j], me[i]
+	me[i].index = i
+	me[j].index = j
+}
+
+func (me *lfuHeap[K]) Push(x any) {
+	e := x.(*lfuEntry[K])
+	e.index = len(*me)
+	*me = append(*me, e)
+}
+
+func (me *lfuHeap[K]) Pop() any {
+	old := *me
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*me = old[:len(old)-1]
+	return e
+}
+
+func (me *LfuPolicy[K]) Candidate() (k K, ok bool) {
+	if len(me.h) == 0 {
+		return
+	}
+	return me.h[0].key, true
+}
+
+func (me *LfuPolicy[K]) Update(k K) {
+	me.clock++
+	if e, ok := me.elems[k]; ok {
+		e.hits++
+		e.last = me.clock
+		heap.Fix(&me.h, e.index)
+		return
+	}
+	e := &lfuEntry[K]{key: k, hits: 1, last: me.clock}
+	me.elems[k] = e
+	heap.Push(&me.h, e)
+}
+
+func (me *LfuPolicy[K]) Forget(k K) {
+	e, ok := me.elems[k]
+	if !ok {
+		return
+	}
+	heap.Remove(&me.h, e.index)
+	delete(me.elems, k)
+}
+
+func (me *LfuPolicy[K]) NumItems() int {
+	return len(me.elems)
+}
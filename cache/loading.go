@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/missinggo/v2/futures"
+)
+
+// A Cache that fills itself by calling a loader on misses. Concurrent misses for a key share a
+// single load. Values can be refreshed in the background once they reach a certain age, while the
+// stale value continues to be served, and load errors can be cached for a while so a failing
+// backend isn't hammered.
+type Loading[K comparable, V any] struct {
+	opts  LoadingOpts[K, V]
+	cache *Cache[K, loadingEntry[V]]
+	now   func() time.Time
+
+	mu sync.Mutex
+	// Loads in progress.
+	loading map[K]*futures.F
+}
+
+type LoadingOpts[K comparable, V any] struct {
+	Load func(ctx context.Context, k K) (V, error)
+	// The byte budget. Zero means unlimited.
+	Capacity int64
+	// The default is NewLruPolicy.
+	Policy func() Policy[K]
+	// Returns the size of a loaded value. The default counts every value as 1.
+	Size func(k K, v V) int64
+	// Values older than this are reloaded in the background on access, and served stale
+	// meanwhile. Zero disables refreshing.
+	RefreshAfter time.Duration
+	// Values older than this aren't served, and the caller waits for a reload. Zero means never.
+	ExpireAfter time.Duration
+	// How long a load error is returned for before retrying, and how long a stale value is served
+	// without retrying after a reload fails. Zero disables caching errors.
+	ErrorTTL time.Duration
+}
+
+type loadingEntry[V any] struct {
+	value  V
+	err    error
+	loaded time.Time
+	// The last failed reload of the value, and when it happened. Reloads aren't retried until
+	// ErrorTTL has passed.
+	reloadErr error
+	failed    time.Time
+}
+
+// Returns whether a reload of the value failed within the last ErrorTTL.
+func (me loadingEntry[V]) reloadFailedRecently(now time.Time, ttl time.Duration) bool {
+	return me.reloadErr != nil && now.Sub(me.failed) < ttl
+}
+
+func NewLoading[K comparable, V any](opts LoadingOpts[K, V]) *Loading[K, V] {
+	return &Loading[K, V]{
+		opts: opts,
+		cache: New(Opts[K, loadingEntry[V]]{
+			Capacity: opts.Capacity,
+			Policy:   opts.Policy,
+		}),
+		now:     time.Now,
+		loading: make(map[K]*futures.F),
+	}
+}
+
+// Returns the value for k, loading it if it's not cached. Returns early if ctx is done, but any
+// load continues for other callers.
+func (me *Loading[K, V]) Get(ctx context.Context, k K) (v V, err error) {
+	if e, ok := me.cache.Get(k); ok {
+		now := me.now()
+		age := now.Sub(e.loaded)
+		switch {
+		case e.err != nil:
+			if age < me.opts.ErrorTTL {
+				return v, e.err
+			}
+		case me.opts.ExpireAfter > 0 && age >= me.opts.ExpireAfter:
+			if e.reloadFailedRecently(now, me.opts.ErrorTTL) {
+				return v, e.reloadErr
+			}
+		case me.opts.RefreshAfter > 0 && age >= me.opts.RefreshAfter:
+			if !e.reloadFailedRecently(now, me.opts.ErrorTTL) {
+				me.Load(ctx, k)
+			}
+			return e.value, nil
+		default:
+			return e.value, nil
+		}
+	}
+	f := me.Load(ctx, k)
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	case <-f.Done():
+	}
+	res, err := f.Result()
+	v, _ = res.(V)
+	return
+}
+
+// Returns the future for a load of k, starting one if there isn't one in progress. The loader is
+// given ctx's values, but isn't canceled with it, as other callers may be waiting on it.
+func (me *Loading[K, V]) Load(ctx context.Context, k K) *futures.F {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if f, ok := me.loading[k]; ok {
+		return f
+	}
+	ctx = context.WithoutCancel(ctx)
+	var f *futures.F
+	f = futures.Start(func() (interface{}, error) {
+		v, err := me.opts.Load(ctx, k)
+		me.mu.Lock()
+		defer me.mu.Unlock()
+		// The key was invalidated or replaced while we were loading.
+		if me.loading[k] != f {
+			return v, err
+		}
+		delete(me.loading, k)
+		me.store(k, v, err)
+		return v, err
+	})
+	me.loading[k] = f
+	return f
+}
+
+// Starts loading k in the background if it isn't cached or already loading.
+func (me *Loading[K, V]) Prefetch(ctx context.Context, k K) {
+	if _, ok := me.cache.Peek(k); ok {
+		return
+	}
+	me.Load(ctx, k)
+}
+
+func (me *Loading[K, V]) store(k K, v V, err error) {
+	e := loadingEntry[V]{value: v, err: err, loaded: me.now()}
+	if err == nil {
+		size := int64(1)
+		if me.opts.Size != nil {
+			size = me.opts.Size(k, v)
+		}
+		me.cache.Update(Item[K, loadingEntry[V]]{Key: k, Value: e, Size: size})
+		return
+	}
+	if prev, ok := me.cache.Peek(k); ok && prev.Value.err == nil {
+		// Keep serving the stale value rather than replace it with an error, but note the failure
+		// so reloads back off.
+		prev.Value.reloadErr = err
+		prev.Value.failed = e.loaded
+		me.cache.Update(prev)
+		return
+	}
+	if me.opts.ErrorTTL == 0 {
+		me.cache.Remove(k)
+		return
+	}
+	// Errors are given a nominal size so they're eventually evicted.
+	me.cache.Update(Item[K, loadingEntry[V]]{Key: k, Value: e, Size: 1})
+}
+
+// Sets the value for k, superseding any load in progress.
+func (me *Loading[K, V]) Put(k K, v V) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	delete(me.loading, k)
+	me.store(k, v, nil)
+}
+
+// Forgets the value for k. Any load in progress isn't cached.
+func (me *Loading[K, V]) Invalidate(k K) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	delete(me.loading, k)
+	me.cache.Remove(k)
+}
+
+func (me *Loading[K, V]) NumItems() int {
+	return me.cache.NumItems()
+}
@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (me *fakeClock) Now() time.Time {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.now
+}
+
+func (me *fakeClock) Advance(d time.Duration) {
+	me.mu.Lock()
+	me.now = me.now.Add(d)
+	me.mu.Unlock()
+}
+
+func TestLoadingSharesLoads(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := NewLoading(LoadingOpts[string, int]{
+		Load: func(ctx context.Context, k string) (int, error) {
+			calls.Add(1)
+			<-release
+			return len(k), nil
+		},
+	})
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.Get(context.Background(), "hello")
+			assert.NoError(t, err)
+			assert.Equal(t, 5, v)
+		}()
+	}
+	// Wait for the load to start before letting it finish.
+	f := c.Load(context.Background(), "hello")
+	close(release)
+	wg.Wait()
+	require.NoError(t, f.Err())
+	assert.EqualValues(t, 1, calls.Load())
+	v, err := c.Get(context.Background(), "hello")
+	require.NoError(t, err)
+	assert.Equal(t, 5, v)
+	assert.EqualValues(t, 1, calls.Load())
+}
+
+func TestLoadingRefreshServesStale(t *testing.T) {
+	clock := fakeClock{now: time.Unix(0, 0)}
+	var gen atomic.Int32
+	c := NewLoading(LoadingOpts[string, int32]{
+		Load: func(ctx context.Context, k string) (int32, error) {
+			return gen.Add(1), nil
+		},
+		RefreshAfter: time.Minute,
+		ExpireAfter:  time.Hour,
+	})
+	c.now = clock.Now
+	ctx := context.Background()
+	v, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, v)
+	clock.Advance(2 * time.Minute)
+	v, _ = c.Get(ctx, "a")
+	assert.EqualValues(t, 1, v)
+	require.NoError(t, c.Load(ctx, "a").Err())
+	v, _ = c.Get(ctx, "a")
+	assert.EqualValues(t, 2, v)
+	// Too old to serve stale.
+	clock.Advance(2 * time.Hour)
+	v, _ = c.Get(ctx, "a")
+	assert.EqualValues(t, 3, v)
+}
+
+func TestLoadingCachesErrors(t *testing.T) {
+	clock := fakeClock{now: time.Unix(0, 0)}
+	var calls atomic.Int32
+	errBackend := errors.New("backend down")
+	c := NewLoading(LoadingOpts[string, string]{
+		Load: func(ctx context.Context, k string) (string, error) {
+			if calls.Add(1) == 1 {
+				return "", errBackend
+			}
+			return k, nil
+		},
+		ErrorTTL: time.Second,
+	})
+	c.now = clock.Now
+	ctx := context.Background()
+	_, err := c.Get(ctx, "a")
+	assert.ErrorIs(t, err, errBackend)
+	_, err = c.Get(ctx, "a")
+	assert.ErrorIs(t, err, errBackend)
+	assert.EqualValues(t, 1, calls.Load())
+	clock.Advance(time.Second)
+	v, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", v)
+}
+
+// Failed refreshes aren't retried until ErrorTTL has passed, and the stale value is served
+// meanwhile.
+func TestLoadingRefreshErrorsBackOff(t *testing.T) {
+	clock := fakeClock{now: time.Unix(0, 0)}
+	var calls atomic.Int32
+	errBackend := errors.New("backend down")
+	release := make(chan struct{})
+	c := NewLoading(LoadingOpts[string, string]{
+		Load: func(ctx context.Context, k string) (string, error) {
+			if calls.Add(1) == 1 {
+				return k, nil
+			}
+			<-release
+			return "", errBackend
+		},
+		RefreshAfter: time.Minute,
+		ExpireAfter:  3 * time.Hour,
+		ErrorTTL:     time.Hour,
+	})
+	c.now = clock.Now
+	ctx := context.Background()
+	v, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", v)
+	clock.Advance(2 * time.Minute)
+	v, err = c.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", v)
+	// Joins the refresh that Get started.
+	f := c.Load(ctx, "a")
+	release <- struct{}{}
+	assert.ErrorIs(t, f.Err(), errBackend)
+	for range 10 {
+		v, err = c.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, "a", v)
+	}
+	assert.EqualValues(t, 2, calls.Load())
+	clock.Advance(time.Hour)
+	v, _ = c.Get(ctx, "a")
+	assert.Equal(t, "a", v)
+	f = c.Load(ctx, "a")
+	release <- struct{}{}
+	assert.ErrorIs(t, f.Err(), errBackend)
+	assert.EqualValues(t, 3, calls.Load())
+	// Once it's too old to serve, the reload error is returned until ErrorTTL has passed.
+	clock.Advance(2 * time.Hour)
+	go func() { release <- struct{}{} }()
+	_, err = c.Get(ctx, "a")
+	assert.ErrorIs(t, err, errBackend)
+	assert.EqualValues(t, 4, calls.Load())
+	_, err = c.Get(ctx, "a")
+	assert.ErrorIs(t, err, errBackend)
+	assert.EqualValues(t, 4, calls.Load())
+}
+
+func TestLoadingContextCanceled(t *testing.T) {
+	release := make(chan struct{})
+	c := NewLoading(LoadingOpts[string, string]{
+		Load: func(ctx context.Context, k string) (string, error) {
+			<-release
+			return k, ctx.Err()
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.Get(ctx, "a")
+	assert.ErrorIs(t, err, context.Canceled)
+	// The load carries on regardless.
+	close(release)
+	require.NoError(t, c.Load(context.Background(), "a").Err())
+	assert.Equal(t, 1, c.NumItems())
+}
+
+func TestLoadingInvalidateDuringLoad(t *testing.T) {
+	release := make(chan struct{})
+	c := NewLoading(LoadingOpts[string, string]{
+		Load: func(ctx context.Context, k string) (string, error) {
+			<-release
+			return "stale", nil
+		},
+	})
+	f := c.Load(context.Background(), "a")
+	c.Put("a", "fresh")
+	close(release)
+	require.NoError(t, f.Err())
+	v, err := c.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", v)
+}
+
+func TestLoadingPrefetch(t *testing.T) {
+	var calls atomic.Int32
+	c := NewLoading(LoadingOpts[string, int]{
+		Load: func(ctx context.Context, k string) (int, error) {
+			calls.Add(1)
+			return len(k), nil
+		},
+	})
+	c.Prefetch(context.Background(), "hello")
+	// Waits for the prefetch rather than starting another load.
+	v, err := c.Get(context.Background(), "hello")
+	require.NoError(t, err)
+	assert.Equal(t, 5, v)
+	c.Prefetch(context.Background(), "hello")
+	assert.EqualValues(t, 1, calls.Load())
+}
@@ -0,0 +1,47 @@
+package cache
+
+import "container/list"
+
+// Evicts the least recently used key.
+type LruPolicy[K comparable] struct {
+	// Front is most recently used.
+	order list.List
+	elems map[K]*list.Element
+}
+
+var _ Policy[string] = (*LruPolicy[string])(nil)
+
+func NewLruPolicy[K comparable]() *LruPolicy[K] {
+	return &LruPolicy[K]{
+		elems: make(map[K]*list.Element),
+	}
+}
+
+func (me *LruPolicy[K]) Candidate() (k K, ok bool) {
+	e := me.order.Back()
+	if e == nil {
+		return
+	}
+	return e.Value.(K), true
+}
+
+func (me *LruPolicy[K]) Update(k K) {
+	if e, ok := me.elems[k]; ok {
+		me.order.MoveToFront(e)
+		return
+	}
+	me.elems[k] = me.order.PushFront(k)
+}
+
+func (me *LruPolicy[K]) Forget(k K) {
+	e, ok := me.elems[k]
+	if !ok {
+		return
+	}
+	me.order.Remove(e)
+	delete(me.elems, k)
+}
+
+func (me *LruPolicy[K]) NumItems() int {
+	return len(me.elems)
+}
@@ -1,12 +1,21 @@
 package cache
 
-type Usage interface {
-	Less(Usage) bool
+// Decides which key to evict next. Policies aren't safe for concurrent use, the Cache serializes
+// calls to them.
+type Policy[K comparable] interface {
+	// Returns the key that should be evicted next.
+	Candidate() (K, bool)
+	// Records an access of k, adding it if it isn't already known.
+	Update(K)
+	Forget(K)
+	NumItems() int
 }
 
-type Policy interface {
-	Candidate() (Key, bool)
-	Update(Key, Usage)
-	Forget(Key)
-	NumItems() int
+// Optionally implemented by a Policy to filter which new keys may displace existing ones when the
+// cache is full.
+type Admitter[K comparable] interface {
+	// Records an access of a key that isn't in the cache.
+	Record(K)
+	// Returns whether k should be added at the cost of evicting victim.
+	Admit(k, victim K) bool
 }
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func candidates[K comparable](p Policy[K]) (ret []K) {
+	for {
+		k, ok := p.Candidate()
+		if !ok {
+			return
+		}
+		ret = append(ret, k)
+		p.Forget(k)
+	}
+}
+
+func TestLruPolicy(t *testing.T) {
+	p := NewLruPolicy[string]()
+	p.Update("a")
+	p.Update("b")
+	p.Update("c")
+	p.Update("a")
+	p.Forget("nope")
+	assert.Equal(t, 3, p.NumItems())
+	assert.Equal(t, []string{"b", "c", "a"}, candidates[string](p))
+}
+
+func TestFifoPolicy(t *testing.T) {
+	p := NewFifoPolicy[string]()
+	p.Update("a")
+	p.Update("b")
+	p.Update("a")
+	assert.Equal(t, []string{"a", "b"}, candidates[string](p))
+}
+
+func TestLfuPolicy(t *testing.T) {
+	p := NewLfuPolicy[string]()
+	p.Update("a")
+	p.Update("a")
+	p.Update("b")
+	p.Update("c")
+	p.Update("b")
+	p.Update("a")
+	assert.Equal(t, []string{"c", "b", "a"}, candidates[string](p))
+	assert.Equal(t, 0, p.NumItems())
+}
@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"hash/maphash"
+	"runtime"
+	"sync/atomic"
+)
+
+// A Cache partitioned by key hash into shards, each with its own lock and Policy, to reduce
+// contention. The capacity is enforced across all shards, but eviction is approximate: the shard
+// being updated evicts its own candidates first, and the others are tried in turn only if it runs
+// out. With keys spread evenly, each shard settles at around its share of the capacity.
+type Sharded[K comparable, V any] struct {
+	seed     maphash.Seed
+	shards   []*Cache[K, V]
+	capacity atomic.Int64
+	filled   atomic.Int64
+	// The shard to try next when the updated one has nothing to evict.
+	next    atomic.Uint32
+	onEvict func(Item[K, V])
+}
+
+type ShardedOpts[K comparable, V any] struct {
+	Opts[K, V]
+	// The number of shards. The default is 4 per CPU.
+	Shards int
+}
+
+func NewSharded[K comparable, V any](opts ShardedOpts[K, V]) *Sharded[K, V] {
+	n := opts.Shards
+	if n <= 0 {
+		n = 4 * runtime.GOMAXPROCS(0)
+	}
+	ret := &Sharded[K, V]{
+		seed:    maphash.MakeSeed(),
+		shards:  make([]*Cache[K, V], n),
+		onEvict: opts.OnEvict,
+	}
+	ret.capacity.Store(opts.Capacity)
+	for i := range ret.shards {
+		// Shards are unlimited, capacity is handled here.
+		ret.shards[i] = New(Opts[K, V]{Policy: opts.Policy})
+	}
+	return ret
+}
+
+func (me *Sharded[K, V]) shard(k K) *Cache[K, V] {
+	return me.shards[maphash.Comparable(me.seed, k)%uint64(len(me.shards))]
+}
+
+func (me *Sharded[K, V]) Get(k K) (V, bool) {
+	return me.shard(k).Get(k)
+}
+
+func (me *Sharded[K, V]) Peek(k K) (Item[K, V], bool) {
+	return me.shard(k).Peek(k)
+}
+
+// Adds or replaces an item, and evicts others if the cache is then over capacity. Returns false if
+// the policy didn't admit the item.
+func (me *Sharded[K, V]) Update(i Item[K, V]) (admitted bool) {
+	s := me.shard(i.Key)
+	s.mu.Lock()
+	// The shard's share of the remaining capacity, for admission.
+	capacity := me.capacity.Load()
+	if capacity > 0 {
+		capacity = max(capacity-me.filled.Load()+s.filled, 1)
+	}
+	delta, admitted := s.update(i, capacity)
+	me.filled.Add(delta)
+	s.mu.Unlock()
+	me.trim(s)
+	return
+}
+
+func (me *Sharded[K, V]) Remove(k K) (i Item[K, V], ok bool) {
+	s := me.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok = s.items[k]
+	if !ok {
+		return
+	}
+	s.remove(k)
+	me.filled.Add(-i.Size)
+	return
+}
+
+func (me *Sharded[K, V]) overCapacity() bool {
+	capacity := me.capacity.Load()
+	return capacity > 0 && me.filled.Load() > capacity
+}
+
+// Evicts items until the cache is within capacity, starting with the given shard.
+func (me *Sharded[K, V]) trim(first *Cache[K, V]) {
+	var evicted []Item[K, V]
+	s := first
+	for me.overCapacity() {
+		i, ok := me.evictFrom(s)
+		if !ok {
+			// Move on to the next shard with anything to evict.
+			start := me.next.Add(1)
+			for j := range uint32(len(me.shards)) {
+				s = me.shards[(start+j)%uint32(len(me.shards))]
+				i, ok = me.evictFrom(s)
+				if ok {
+					break
+				}
+			}
+			if !ok {
+				break
+			}
+		}
+		evicted = append(evicted, i)
+	}
+	if me.onEvict != nil {
+		for _, i := range evicted {
+			me.onEvict(i)
+		}
+	}
+}
+
+func (me *Sharded[K, V]) evictFrom(s *Cache[K, V]) (i Item[K, V], ok bool) {
+	s.mu.Lock()
+	i, ok = s.evictOne()
+	s.mu.Unlock()
+	if ok {
+		me.filled.Add(-i.Size)
+	}
+	return
+}
+
+func (me *Sharded[K, V]) Capacity() int64 {
+	return me.capacity.Load()
+}
+
+// Changes the byte budget, evicting items if the cache no longer fits.
+func (me *Sharded[K, V]) SetCapacity(capacity int64) {
+	me.capacity.Store(capacity)
+	me.trim(me.shards[me.next.Add(1)%uint32(len(me.shards))])
+}
+
+func (me *Sharded[K, V]) Filled() int64 {
+	return me.filled.Load()
+}
+
+func (me *Sharded[K, V]) NumItems() (ret int) {
+	for _, s := range me.shards {
+		ret += s.NumItems()
+	}
+	return
+}
+
+func (me *Sharded[K, V]) Clear() {
+	for _, s := range me.shards {
+		s.mu.Lock()
+		me.filled.Add(-s.filled)
+		s.clear()
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedCapacity(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		evicted int64
+	)
+	c := NewSharded(ShardedOpts[int, int]{
+		Opts: Opts[int, int]{
+			Capacity: 100,
+			OnEvict: func(i Item[int, int]) {
+				mu.Lock()
+				evicted += i.Size
+				mu.Unlock()
+			},
+		},
+		Shards: 8,
+	})
+	var wg sync.WaitGroup
+	for g := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				c.Update(Item[int, int]{Key: g*1000 + i, Value: i, Size: 3})
+			}
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, c.Filled(), int64(100))
+	assert.Equal(t, 3*int64(c.NumItems()), c.Filled())
+	assert.EqualValues(t, 4*1000*3, c.Filled()+evicted)
+	c.SetCapacity(10)
+	assert.LessOrEqual(t, c.Filled(), int64(10))
+	c.Clear()
+	assert.EqualValues(t, 0, c.Filled())
+	assert.Equal(t, 0, c.NumItems())
+}
+
+func TestShardedEvictsFromOtherShards(t *testing.T) {
+	c := NewSharded(ShardedOpts[string, struct{}]{
+		Opts:   Opts[string, struct{}]{Capacity: 10},
+		Shards: 4,
+	})
+	c.Update(Item[string, struct{}]{Key: "a", Size: 6})
+	// Whichever shard b lands in, a has to go.
+	c.Update(Item[string, struct{}]{Key: "b", Size: 6, Pinned: true})
+	_, ok := c.Peek("a")
+	assert.False(t, ok)
+	assert.EqualValues(t, 6, c.Filled())
+	i, ok := c.Remove("b")
+	assert.True(t, ok)
+	assert.True(t, i.Pinned)
+	assert.EqualValues(t, 0, c.Filled())
+}
+
+type benchCache interface {
+	Get(string) (int, bool)
+	Update(Item[string, int]) bool
+}
+
+func benchmarkCache(b *testing.B, c benchCache) {
+	const numKeys = 1 << 14
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			k := keys[i%numKeys]
+			// Mostly reads, with a miss filling the key in.
+			if _, ok := c.Get(k); !ok || i%8 == 0 {
+				c.Update(Item[string, int]{Key: k, Value: i, Size: 1})
+			}
+			i += 7
+		}
+	})
+}
+
+func BenchmarkCache(b *testing.B) {
+	benchmarkCache(b, New(Opts[string, int]{Capacity: 1 << 12}))
+}
+
+func BenchmarkSharded(b *testing.B) {
+	benchmarkCache(b, NewSharded(ShardedOpts[string, int]{Opts: Opts[string, int]{Capacity: 1 << 12}}))
+}
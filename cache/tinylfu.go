@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+// Wraps a Policy with a W-TinyLFU style admission filter. Access frequencies are estimated with a
+// count-min sketch, fronted by a doorkeeper bloom filter so keys seen only once don't occupy it. A
+// new key is only admitted to a full cache if it's been seen more often than the key it would
+// evict, which keeps scans of one-off keys from flushing the working set.
+type TinyLfuPolicy[K comparable] struct {
+	Policy[K]
+	seed maphash.Seed
+	// Rows of counters, each indexed by a different hash of the key.
+	sketch [tinyLfuDepth][]uint8
+	mask   uint64
+	// Keys seen at least once since the last reset.
+	doorkeeper     []uint64
+	doorkeeperMask uint64
+	// Accesses since the last reset, and the number at which counters are halved.
+	samples, resetAt int
+}
+
+const (
+	tinyLfuDepth   = 4
+	tinyLfuMaxFreq = 15
+)
+
+var (
+	_ Policy[string]   = (*TinyLfuPolicy[string])(nil)
+	_ Admitter[string] = (*TinyLfuPolicy[string])(nil)
+)
+
+// Size should be around the expected number of items in the cache.
+func NewTinyLfuPolicy[K comparable](p Policy[K], size int) *TinyLfuPolicy[K] {
+	// Around 8 counters or bits per key keeps collisions to a few percent.
+	width := 1 << bits.Len(uint(max(8*size, 64)-1))
+	ret := &TinyLfuPolicy[K]{
+		Policy:         p,
+		seed:           maphash.MakeSeed(),
+		mask:           uint64(width - 1),
+		doorkeeper:     make([]uint64, width/64),
+		doorkeeperMask: uint64(width - 1),
+		resetAt:        10 * width,
+	}
+	for i := range ret.sketch {
+		ret.sketch[i] = make([]uint8, width)
+	}
+	return ret
+}
+
+// Returns hashes of k for each sketch row, derived from a single hash. They're masked to the row
+// width when used.
+func (me *TinyLfuPolicy[K]) indexes(k K) (ret [tinyLfuDepth]uint64) {
+	h := maphash.Comparable(me.seed, k)
+	lo, hi := h, h>>32|h<<32
+	for i := range ret {
+		ret[i] = lo + uint64(i)*hi
+	}
+	return
+}
+
+func (me *TinyLfuPolicy[K]) doorkeeperBit(idx uint64) (word int, bit uint64) {
+	idx &= me.doorkeeperMask
+	return int(idx / 64), 1 << (idx % 64)
+}
+
+func (me *TinyLfuPolicy[K]) inDoorkeeper(idxs [tinyLfuDepth]uint64) bool {
+	for _, idx := range idxs[:2] {
+		w, b := me.doorkeeperBit(idx)
+		if me.doorkeeper[w]&b == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (me *TinyLfuPolicy[K]) Record(k K) {
+	idxs := me.indexes(k)
+	if !me.inDoorkeeper(idxs) {
+		for _, idx := range idxs[:2] {
+			w, b := me.doorkeeperBit(idx)
+			me.doorkeeper[w] |= b
+		}
+	} else {
+		for i, idx := range idxs {
+			c := &me.sketch[i][idx&me.mask]
+			if *c < tinyLfuMaxFreq {
+				*c++
+			}
+		}
+	}
+	me.samples++
+	if me.samples >= me.resetAt {
+		me.reset()
+	}
+}
+
+// Halves all the counters and clears the doorkeeper, so that old popularity fades.
+func (me *TinyLfuPolicy[K]) reset() {
+	for _, row := range me.sketch {
+		for i := range row {
+			row[i] /= 2
+		}
+	}
+	clear(me.doorkeeper)
+	me.samples /= 2
+}
+
+// Returns the estimated number of accesses of k.
+func (me *TinyLfuPolicy[K]) estimate(k K) (ret int) {
+	idxs := me.indexes(k)
+	ret = tinyLfuMaxFreq
+	for i, idx := range idxs {
+		ret = min(ret, int(me.sketch[i][idx&me.mask]))
+	}
+	if me.inDoorkeeper(idxs) {
+		ret++
+	}
+	return
+}
+
+func (me *TinyLfuPolicy[K]) Admit(k, victim K) bool {
+	return me.estimate(k) > me.estimate(victim)
+}
+
+func (me *TinyLfuPolicy[K]) Update(k K) {
+	me.Record(k)
+	me.Policy.Update(k)
+}
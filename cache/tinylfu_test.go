@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTinyLfuEstimate(t *testing.T) {
+	p := NewTinyLfuPolicy[string](NewLruPolicy[string](), 100)
+	assert.Equal(t, 0, p.estimate("a"))
+	p.Record("a")
+	assert.Equal(t, 1, p.estimate("a"))
+	for range 3 {
+		p.Record("a")
+	}
+	assert.Equal(t, 4, p.estimate("a"))
+	assert.True(t, p.Admit("a", "b"))
+	assert.False(t, p.Admit("b", "a"))
+	p.reset()
+	assert.Equal(t, 1, p.estimate("a"))
+}
+
+func TestTinyLfuResistsScan(t *testing.T) {
+	c := New(Opts[int, struct{}]{
+		Capacity: 10,
+		Policy: func() Policy[int] {
+			return NewTinyLfuPolicy[int](NewLruPolicy[int](), 100)
+		},
+	})
+	for range 5 {
+		for k := range 10 {
+			if _, ok := c.Get(k); !ok {
+				c.Update(Item[int, struct{}]{Key: k, Size: 1})
+			}
+		}
+	}
+	for k := 100; k < 200; k++ {
+		if _, ok := c.Get(k); !ok {
+			c.Update(Item[int, struct{}]{Key: k, Size: 1})
+		}
+	}
+	// The sketch can overestimate a scan key if it collides with hot keys in every row.
+	survivors := 0
+	for k := range 10 {
+		if _, ok := c.Peek(k); ok {
+			survivors++
+		}
+	}
+	assert.GreaterOrEqual(t, survivors, 9)
+	// A key that keeps coming back is eventually admitted.
+	for range 5 {
+		if _, ok := c.Get(100); !ok {
+			c.Update(Item[int, struct{}]{Key: 100, Size: 1})
+		}
+	}
+	_, ok := c.Peek(100)
+	assert.True(t, ok)
+	assert.Equal(t, 10, c.NumItems())
+}
+
+func TestTinyLfuUpdateReportsAdmission(t *testing.T) {
+	c := New(Opts[string, struct{}]{
+		Capacity: 2,
+		Policy: func() Policy[string] {
+			return NewTinyLfuPolicy[string](NewLruPolicy[string](), 100)
+		},
+	})
+	assert.True(t, c.Update(Item[string, struct{}]{Key: "a", Size: 1}))
+	assert.True(t, c.Update(Item[string, struct{}]{Key: "b", Size: 1}))
+	c.Get("a")
+	c.Get("b")
+	assert.False(t, c.Update(Item[string, struct{}]{Key: "c", Size: 1}))
+	_, ok := c.Peek("c")
+	assert.False(t, ok)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/missinggo/v2/filecache"
+)
+
+// The admin API is JSON over HTTP under /admin/. Items are named by the path query parameter.
+//
+//	GET  /admin/info               cache info
+//	GET  /admin/items              items sorted by path, filtered by prefix, paged with after and limit
+//	GET  /admin/item?path=         a single item
+//	POST /admin/evict?path=        evict an item
+//	POST /admin/pin?path=          pin an item
+//	POST /admin/unpin?path=        unpin an item
+//	POST /admin/capacity?bytes=    set the capacity, -1 for unlimited
+//	POST /admin/trim               evict items until the cache is within capacity
+//	POST /admin/rescan             refresh the items from the filesystem
+
+const defaultAdminListLimit = 1000
+
+type adminItem struct {
+	Path      string            `json:"path"`
+	Size      int64             `json:"size"`
+	Accessed  time.Time         `json:"accessed"`
+	Complete  bool              `json:"complete"`
+	Completed []filecache.Range `json:"completed,omitempty"`
+	Hash      string            `json:"hash,omitempty"`
+	Pinned    bool              `json:"pinned,omitempty"`
+	Expires   *time.Time        `json:"expires,omitempty"`
+}
+
+func newAdminItem(ii filecache.ItemInfo) (ret adminItem) {
+	ret = adminItem{
+		Path:      string(ii.Path),
+		Size:      ii.Size,
+		Accessed:  ii.Accessed,
+		Complete:  ii.IsComplete(),
+		Completed: ii.Completed,
+		Hash:      ii.Hash,
+		Pinned:    ii.Pinned,
+	}
+	if !ii.Expires.IsZero() {
+		ret.Expires = &ii.Expires
+	}
+	return
+}
+
+type adminItemList struct {
+	Items []adminItem `json:"items"`
+	// Pass as after to get the next page. Empty if this is the last page.
+	Next string `json:"next,omitempty"`
+}
+
+type adminInfo struct {
+	Capacity     int64                          `json:"capacity"`
+	Filled       int64                          `json:"filled"`
+	NumItems     int                            `json:"numItems"`
+	NumPinned    int                            `json:"numPinned"`
+	PinnedFilled int64                          `json:"pinnedFilled"`
+	Quotas       map[string]filecache.QuotaInfo `json:"quotas,omitempty"`
+}
+
+func (me *server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/info", me.adminGetInfo)
+	mux.HandleFunc("GET /admin/items", me.adminListItems)
+	mux.HandleFunc("GET /admin/item", me.adminGetItem)
+	mux.HandleFunc("POST /admin/evict", me.adminItemAction(me.c.Evict))
+	mux.HandleFunc("POST /admin/pin", me.adminItemAction(me.c.Pin))
+	mux.HandleFunc("POST /admin/unpin", me.adminItemAction(me.c.Unpin))
+	mux.HandleFunc("POST /admin/capacity", me.adminSetCapacity)
+	mux.HandleFunc("POST /admin/trim", func(w http.ResponseWriter, r *http.Request) {
+		me.c.TrimToCapacity()
+		me.adminGetInfo(w, r)
+	})
+	mux.HandleFunc("POST /admin/rescan", func(w http.ResponseWriter, r *http.Request) {
+		err := me.c.Rescan()
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		me.adminGetInfo(w, r)
+	})
+	return me.adminOnly(mux)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+type adminErrorBody struct {
+	Error string `json:"error"`
+}
+
+func adminError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, filecache.ErrBadPath):
+		status = http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(adminErrorBody{err.Error()})
+}
+
+func (me *server) adminGetInfo(w http.ResponseWriter, r *http.Request) {
+	info := me.c.Info()
+	writeJSON(w, adminInfo{
+		Capacity:     info.Capacity,
+		Filled:       info.Filled,
+		NumItems:     info.NumItems,
+		NumPinned:    info.NumPinned,
+		PinnedFilled: info.PinnedFilled,
+		Quotas:       info.Quotas,
+	})
+}
+
+func (me *server) adminListItems(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	after := q.Get("after")
+	limit := defaultAdminListLimit
+	if s := q.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			adminError(w, errors.New("bad limit"))
+			return
+		}
+	}
+	var items []adminItem
+	me.c.WalkItems(func(ii filecache.ItemInfo) {
+		p := string(ii.Path)
+		if strings.HasPrefix(p, prefix) && p > after {
+			items = append(items, newAdminItem(ii))
+		}
+	})
+	slices.SortFunc(items, func(a, b adminItem) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	var ret adminItemList
+	if len(items) > limit {
+		items = items[:limit]
+		ret.Next = items[limit-1].Path
+	}
+	ret.Items = items
+	if ret.Items == nil {
+		ret.Items = []adminItem{}
+	}
+	writeJSON(w, ret)
+}
+
+func (me *server) adminGetItem(w http.ResponseWriter, r *http.Request) {
+	ii, ok := me.c.Item(r.URL.Query().Get("path"))
+	if !ok {
+		adminError(w, os.ErrNotExist)
+		return
+	}
+	writeJSON(w, newAdminItem(ii))
+}
+
+func (me *server) adminItemAction(action func(path string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := action(r.URL.Query().Get("path"))
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (me *server) adminSetCapacity(w http.ResponseWriter, r *http.Request) {
+	capacity, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+	if err != nil {
+		adminError(w, errors.New("bad bytes"))
+		return
+	}
+	me.c.SetCapacity(capacity)
+	me.adminGetInfo(w, r)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminCommands(t *testing.T) {
+	s, hs := newTestServer(t)
+	var err error
+	s.tokens, err = parseTokens(strings.NewReader(testTokens))
+	require.NoError(t, err)
+	for _, p := range []string{"a/1", "a/2", "a/3", "b"} {
+		resp := doRequest(t, "PUT", hs.URL+"/"+p, "hello", http.Header{"Authorization": {"Bearer letmein"}})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	c := &adminClient{base: hs.URL, token: "letmein", client: http.DefaultClient}
+	run := func(cmd string, args ...string) string {
+		var buf bytes.Buffer
+		require.NoError(t, runAdminCommand(&buf, c, cmd, args))
+		return buf.String()
+	}
+	assert.Contains(t, run("info"), `"numItems": 4`)
+	var list adminItemList
+	require.NoError(t, c.do("GET", "items", map[string][]string{"prefix": {"a/"}, "limit": {"2"}}, &list))
+	require.Len(t, list.Items, 2)
+	assert.Equal(t, "a/2", list.Next)
+	after := list.Next
+	list = adminItemList{}
+	require.NoError(t, c.do("GET", "items", map[string][]string{"prefix": {"a/"}, "after": {after}}, &list))
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "a/3", list.Items[0].Path)
+	assert.Empty(t, list.Next)
+	assert.Equal(t, 3, strings.Count(run("ls", "a/"), "\n"))
+
+	run("pin", "b")
+	assert.Contains(t, run("stat", "b"), `"pinned": true`)
+	run("evict", "a/1")
+	var buf bytes.Buffer
+	err = runAdminCommand(&buf, c, "stat", []string{"a/1"})
+	assert.ErrorContains(t, err, "404")
+	assert.Contains(t, run("capacity", "5B"), `"capacity": 5`)
+	// Only the pinned item fits.
+	assert.Contains(t, run("trim"), `"numItems": 1`)
+	assert.Contains(t, run("rescan"), `"numItems": 1`)
+
+	c.token = "hunter2"
+	err = runAdminCommand(&buf, c, "info", nil)
+	assert.ErrorContains(t, err, "403")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/anacrolix/tagflag"
+	"github.com/dustin/go-humanize"
+)
+
+// Talks to the admin API of a running server.
+type adminClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+// Sends a request to the admin endpoint, and decodes the JSON response into v if it's not nil.
+func (me *adminClient) do(method, endpoint string, q url.Values, v any) (err error) {
+	u := me.base + "/admin/" + endpoint
+	if len(q) != 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return
+	}
+	if me.token != "" {
+		req.Header.Set("Authorization", "Bearer "+me.token)
+	}
+	resp, err := me.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var body adminErrorBody
+		b, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(b, &body) != nil || body.Error == "" {
+			body.Error = string(b)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, body.Error)
+	}
+	if v == nil {
+		return
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func pathQuery(args []string) (url.Values, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected a path")
+	}
+	return url.Values{"path": {args[0]}}, nil
+}
+
+// Runs an admin command, writing its output to w.
+func runAdminCommand(w io.Writer, c *adminClient, cmd string, args []string) (err error) {
+	switch cmd {
+	case "info", "trim", "rescan":
+		method := "POST"
+		if cmd == "info" {
+			method = "GET"
+		}
+		var info adminInfo
+		err = c.do(method, cmd, nil, &info)
+		if err != nil {
+			return
+		}
+		return printJSON(w, info)
+	case "ls":
+		q := url.Values{"limit": {strconv.Itoa(defaultAdminListLimit)}}
+		if len(args) > 0 {
+			q.Set("prefix", args[0])
+		}
+		for {
+			var list adminItemList
+			err = c.do("GET", "items", q, &list)
+			if err != nil {
+				return
+			}
+			for _, i := range list.Items {
+				pinned := ""
+				if i.Pinned {
+					pinned = "\tpinned"
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s%s\n", i.Accessed, i.Size, i.Path, pinned)
+			}
+			if list.Next == "" {
+				return
+			}
+			q.Set("after", list.Next)
+		}
+	case "stat":
+		var q url.Values
+		q, err = pathQuery(args)
+		if err != nil {
+			return
+		}
+		var item adminItem
+		err = c.do("GET", "item", q, &item)
+		if err != nil {
+			return
+		}
+		return printJSON(w, item)
+	case "evict", "pin", "unpin":
+		var q url.Values
+		q, err = pathQuery(args)
+		if err != nil {
+			return
+		}
+		return c.do("POST", cmd, q, nil)
+	case "capacity":
+		if len(args) != 1 {
+			return fmt.Errorf("expected a capacity")
+		}
+		capacity := int64(-1)
+		if args[0] != "-1" && args[0] != "unlimited" {
+			var bytes uint64
+			bytes, err = humanize.ParseBytes(args[0])
+			if err != nil {
+				return
+			}
+			capacity = int64(bytes)
+		}
+		var info adminInfo
+		err = c.do("POST", "capacity", url.Values{"bytes": {strconv.FormatInt(capacity, 10)}}, &info)
+		if err != nil {
+			return
+		}
+		return printJSON(w, info)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// Handles "filecache admin ...".
+func adminMain(args []string) {
+	flags := struct {
+		Server   string `help:"base URL of the server"`
+		Token    string `help:"bearer token with admin permission, defaults to $FILECACHE_TOKEN"`
+		Insecure bool   `help:"don't verify the server's certificate"`
+		tagflag.StartPos
+		Command string   `help:"info, ls [prefix], stat path, evict path, pin path, unpin path, capacity bytes, trim or rescan"`
+		Args    []string `arity:"*"`
+	}{
+		Server: "https://localhost:2076",
+	}
+	tagflag.ParseArgs(&flags, args, tagflag.Program("filecache admin"))
+	if flags.Token == "" {
+		flags.Token = os.Getenv("FILECACHE_TOKEN")
+	}
+	c := &adminClient{
+		base:   flags.Server,
+		token:  flags.Token,
+		client: http.DefaultClient,
+	}
+	if flags.Insecure {
+		c.client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+	err := runAdminCommand(os.Stdout, c, flags.Command, flags.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access is granted by tokens listed in a file, one grant per line:
+//
+//	id secret perms [prefix]
+//
+// perms is some of r (read), w (write), d (delete) and a (admin). The grant applies to items under
+// prefix, or everything if it's omitted. A token can have several lines. Blank lines and those
+// starting with # are ignored.
+//
+// Requests present the secret as a bearer token, or carry a URL signed with it: the key, expires
+// and signature query parameters are the token id, a Unix time, and the hex HMAC-SHA256 of the
+// method, path and expiry, keyed with the secret.
+
+type permissions uint8
+
+const (
+	permRead permissions = 1 << iota
+	permWrite
+	permDelete
+	permAdmin
+)
+
+func parsePermissions(s string) (ret permissions, err error) {
+	for _, r := range s {
+		switch r {
+		case 'r':
+			ret |= permRead
+		case 'w':
+			ret |= permWrite
+		case 'd':
+			ret |= permDelete
+		case 'a':
+			ret |= permAdmin
+		default:
+			err = fmt.Errorf("unknown permission %q", r)
+			return
+		}
+	}
+	return
+}
+
+type grant struct {
+	perms permissions
+	// Without leading or trailing slashes. Empty matches everything.
+	prefix string
+}
+
+type token struct {
+	id     string
+	secret string
+	// Bearer tokens are compared by hash, so the comparison doesn't depend on the secret's length.
+	secretHash [sha256.Size]byte
+	grants     []grant
+}
+
+func (me *token) allows(perms permissions, path string) bool {
+	for _, g := range me.grants {
+		if g.perms&perms != perms {
+			continue
+		}
+		if g.prefix == "" || path == g.prefix || strings.HasPrefix(path, g.prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type tokens struct {
+	byID map[string]*token
+}
+
+func loadTokens(path string) (*tokens, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseTokens(f)
+}
+
+func parseTokens(r io.Reader) (ret *tokens, err error) {
+	ret = &tokens{byID: make(map[string]*token)}
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: expected id, secret, permissions and optional prefix", lineNum)
+		}
+		var g grant
+		g.perms, err = parsePermissions(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if len(fields) == 4 {
+			g.prefix = strings.Trim(fields[3], "/")
+		}
+		t, ok := ret.byID[fields[0]]
+		if !ok {
+			t = &token{id: fields[0], secret: fields[1], secretHash: sha256.Sum256([]byte(fields[1]))}
+			ret.byID[t.id] = t
+		} else if t.secret != fields[1] {
+			return nil, fmt.Errorf("line %d: token %q has a different secret", lineNum, t.id)
+		}
+		t.grants = append(t.grants, g)
+	}
+	err = s.Err()
+	return
+}
+
+var (
+	errNoCredentials  = errors.New("no credentials")
+	errBadCredentials = errors.New("bad credentials")
+)
+
+func urlSignature(secret, method, path string, expires int64) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%s\n%s\n%d", method, path, expires)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Returns the query parameters that sign a request for the path.
+func signURL(id, secret, method, path string, expires time.Time) url.Values {
+	return url.Values{
+		"key":       {id},
+		"expires":   {strconv.FormatInt(expires.Unix(), 10)},
+		"signature": {urlSignature(secret, method, path, expires.Unix())},
+	}
+}
+
+// Returns the token the request carries.
+func (me *tokens) authenticate(r *http.Request, now time.Time) (*token, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		secret, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return nil, errBadCredentials
+		}
+		h := sha256.Sum256([]byte(secret))
+		// Check every token, so the time taken doesn't depend on which matched.
+		var match *token
+		for _, t := range me.byID {
+			if subtle.ConstantTimeCompare(h[:], t.secretHash[:]) == 1 {
+				match = t
+			}
+		}
+		if match == nil {
+			return nil, errBadCredentials
+		}
+		return match, nil
+	}
+	q := r.URL.Query()
+	if !q.Has("signature") {
+		return nil, errNoCredentials
+	}
+	t, ok := me.byID[q.Get("key")]
+	if !ok {
+		return nil, errBadCredentials
+	}
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return nil, errBadCredentials
+	}
+	want := urlSignature(t.secret, r.Method, r.URL.Path, expires)
+	if !hmac.Equal([]byte(q.Get("signature")), []byte(want)) {
+		return nil, errBadCredentials
+	}
+	return t, nil
+}
+
+// Returns whether the request may proceed, responding to it if not. Everything is allowed if
+// there are no tokens configured.
+func (me *server) authorized(w http.ResponseWriter, r *http.Request, perms permissions, path string) bool {
+	if me.tokens == nil {
+		return true
+	}
+	t, err := me.tokens.authenticate(r, time.Now())
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="filecache"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if !t.allows(perms, path) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (me *server) adminOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if me.authorized(w, r, permAdmin, "") {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
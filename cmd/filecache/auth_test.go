@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTokens = `
+# Readers of anything public.
+reader s3cret r public/
+writer hunter2 rw uploads
+writer hunter2 r
+admin letmein rwda
+`
+
+func TestParseTokens(t *testing.T) {
+	ts, err := parseTokens(strings.NewReader(testTokens))
+	require.NoError(t, err)
+	assert.Len(t, ts.byID, 3)
+	w := ts.byID["writer"]
+	assert.True(t, w.allows(permWrite, "uploads/a"))
+	assert.True(t, w.allows(permWrite, "uploads"))
+	assert.False(t, w.allows(permWrite, "uploadsfoo"))
+	assert.True(t, w.allows(permRead, "anything"))
+	assert.False(t, w.allows(permDelete, "uploads/a"))
+	_, err = parseTokens(strings.NewReader("a b x\n"))
+	assert.Error(t, err)
+	_, err = parseTokens(strings.NewReader("a b r\na c w\n"))
+	assert.Error(t, err)
+}
+
+func TestAuth(t *testing.T) {
+	s, hs := newTestServer(t)
+	var err error
+	s.tokens, err = parseTokens(strings.NewReader(testTokens))
+	require.NoError(t, err)
+	bearer := func(secret string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + secret}}
+	}
+	resp := doRequest(t, "GET", hs.URL+"/public/a", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doRequest(t, "GET", hs.URL+"/public/a", "", bearer("nope"))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doRequest(t, "PUT", hs.URL+"/public/a", "hello", bearer("s3cret"))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = doRequest(t, "PUT", hs.URL+"/public/a", "hello", bearer("letmein"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doRequest(t, "GET", hs.URL+"/public/a", "", bearer("s3cret"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doRequest(t, "DELETE", hs.URL+"/public/a", "", bearer("hunter2"))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = doRequest(t, "GET", hs.URL+"/status", "", bearer("hunter2"))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = doRequest(t, "GET", hs.URL+"/status", "", bearer("letmein"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestSignedURL(t *testing.T) {
+	s, hs := newTestServer(t)
+	var err error
+	s.tokens, err = parseTokens(strings.NewReader(testTokens))
+	require.NoError(t, err)
+	q := signURL("writer", "hunter2", "PUT", "/uploads/a", time.Now().Add(time.Minute))
+	resp := doRequest(t, "PUT", hs.URL+"/uploads/a?"+q.Encode(), "hello", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// The signature is for PUT only.
+	resp = doRequest(t, "DELETE", hs.URL+"/uploads/a?"+q.Encode(), "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	q = signURL("writer", "hunter2", "PUT", "/uploads/a", time.Now().Add(-time.Second))
+	resp = doRequest(t, "PUT", hs.URL+"/uploads/a?"+q.Encode(), "hello", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	r := httptest.NewRequest("GET", "/uploads/a", nil)
+	_, err = s.tokens.authenticate(r, time.Now())
+	assert.ErrorIs(t, err, errNoCredentials)
+}
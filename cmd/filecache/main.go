@@ -3,78 +3,95 @@ package main
 import (
 	"crypto/tls"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"regexp"
-	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/anacrolix/envpprof"
-	"github.com/anacrolix/missinggo/filecache"
 	"github.com/anacrolix/tagflag"
 	"github.com/dustin/go-humanize"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/anacrolix/missinggo/v2"
+	"github.com/anacrolix/missinggo/v2/filecache"
 )
 
-var c *filecache.Cache
-
-func handleNewData(w http.ResponseWriter, path string, offset int64, r io.Reader) (served bool) {
-	f, err := c.OpenFile(path, os.O_CREATE|os.O_WRONLY)
-	if err != nil {
-		log.Print(err)
-		http.Error(w, "couldn't open file", http.StatusInternalServerError)
-		return true
-	}
-	defer f.Close()
-	f.Seek(offset, os.SEEK_SET)
-	_, err = io.Copy(f, r)
-	if err != nil {
-		log.Print(err)
-		c.Remove(path)
-		http.Error(w, "didn't complete", http.StatusInternalServerError)
-		return true
+// Parses namespace=bytes quota arguments.
+func parseQuotas(args []string) (ret map[string]int64, err error) {
+	for _, arg := range args {
+		ns, size, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad quota %q", arg)
+		}
+		var bytes uint64
+		bytes, err = humanize.ParseBytes(size)
+		if err != nil {
+			return nil, fmt.Errorf("parsing quota %q: %w", arg, err)
+		}
+		if ret == nil {
+			ret = make(map[string]int64)
+		}
+		ret[ns] = int64(bytes)
 	}
 	return
 }
 
-// Parses out the first byte from a Content-Range header. Returns 0 if it
-// isn't found, which is what is implied if there is no header.
-func parseContentRangeFirstByte(s string) int64 {
-	matches := regexp.MustCompile(`(\d+)-`).FindStringSubmatch(s)
-	if matches == nil {
-		return 0
-	}
-	ret, _ := strconv.ParseInt(matches[1], 0, 64)
-	return ret
-}
-
-func handleDelete(w http.ResponseWriter, path string) {
-	err := c.Remove(path)
-	if err != nil {
-		log.Print(err)
-		http.Error(w, "didn't work", http.StatusInternalServerError)
-		return
-	}
+var policies = map[string]func() filecache.Policy{
+	"lru":  filecache.NewLruPolicy,
+	"lfu":  filecache.NewLfuPolicy,
+	"2q":   filecache.New2QPolicy,
+	"gdsf": filecache.NewGdsfPolicy,
 }
 
 func main() {
 	log.SetFlags(log.Flags() | log.Lshortfile)
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		adminMain(os.Args[2:])
+		return
+	}
 	args := struct {
-		Capacity tagflag.Bytes `short:"c"`
-		Addr     string
+		Capacity     tagflag.Bytes `short:"c"`
+		Addr         string
+		Index        bool          `help:"maintain a persistent index of items in the cache root"`
+		Policy       string        `help:"eviction policy: lru, lfu, 2q or gdsf"`
+		Quota        []string      `help:"namespace=bytes quota for a top-level directory"`
+		TTL          time.Duration `help:"default time items expire after entering the cache"`
+		MaxIdle      time.Duration `help:"default time items expire after going unaccessed"`
+		Tokens       string        `help:"file of access tokens, one grant per line: id secret perms [prefix]"`
+		Peer         []string      `help:"base URL of a server sharing keys, which may be this one"`
+		Self         string        `help:"this server's base URL as given in the peers"`
+		PeerToken    string        `help:"bearer token sent to peers"`
+		PeerInsecure bool          `help:"don't verify peer certificates"`
+		KeepLocal    bool          `help:"keep copies of items fetched from peers"`
 	}{
 		Capacity: -1,
 		Addr:     "localhost:2076",
+		Policy:   "lru",
 	}
 	tagflag.Parse(&args)
+	newPolicy, ok := policies[args.Policy]
+	if !ok {
+		log.Fatalf("unknown eviction policy %q", args.Policy)
+	}
+	quotas, err := parseQuotas(args.Quota)
+	if err != nil {
+		log.Fatal(err)
+	}
 	root, err := os.Getwd()
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Printf("cache root at %q", root)
-	c, err = filecache.NewCache(root)
+	c, err := filecache.NewCacheOpts(root, filecache.CacheOpts{
+		Index:   args.Index,
+		Policy:  newPolicy,
+		Quotas:  quotas,
+		TTL:     args.TTL,
+		MaxIdle: args.MaxIdle,
+	})
 	if err != nil {
 		log.Fatalf("error creating cache: %s", err)
 	}
@@ -84,55 +101,43 @@ func main() {
 		c.SetCapacity(args.Capacity.Int64())
 		log.Printf("setting capacity to %s bytes", humanize.Comma(args.Capacity.Int64()))
 	}
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		p := r.URL.Path[1:]
-		switch r.Method {
-		case "DELETE":
-			log.Printf("%s %s", r.Method, r.RequestURI)
-			handleDelete(w, p)
-			return
-		case "PUT", "PATCH", "POST":
-			contentRange := r.Header.Get("Content-Range")
-			firstByte := parseContentRangeFirstByte(contentRange)
-			log.Printf("%s (%d-) %s", r.Method, firstByte, r.RequestURI)
-			handleNewData(w, p, firstByte, r.Body)
-			return
-		}
-		log.Printf("%s %s %s", r.Method, r.Header.Get("Range"), r.RequestURI)
-		f, err := c.OpenFile(p, os.O_RDONLY)
-		if os.IsNotExist(err) {
-			http.NotFound(w, r)
-			return
-		}
+	prometheus.MustRegister(c)
+	s := &server{c: c}
+	if args.Tokens != "" {
+		s.tokens, err = loadTokens(args.Tokens)
 		if err != nil {
-			log.Printf("couldn't open requested file: %s", err)
-			http.Error(w, "couldn't open file", http.StatusInternalServerError)
-			return
+			log.Fatalf("error loading tokens: %s", err)
 		}
-		defer func() {
-			go f.Close()
-		}()
-		info, _ := f.Stat()
-		w.Header().Set("Content-Range", fmt.Sprintf("*/%d", info.Size()))
-		http.ServeContent(w, r, p, info.ModTime(), f)
-	})
-	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		info := c.Info()
-		fmt.Fprintf(w, "Capacity: %d\n", info.Capacity)
-		fmt.Fprintf(w, "Current Size: %d\n", info.Filled)
-		fmt.Fprintf(w, "Item Count: %d\n", info.NumItems)
-	})
-	http.HandleFunc("/lru", func(w http.ResponseWriter, r *http.Request) {
-		c.WalkItems(func(item filecache.ItemInfo) {
-			fmt.Fprintf(w, "%s\t%d\t%s\n", item.Accessed, item.Size, item.Path)
+	} else {
+		log.Printf("no tokens file given, access is unrestricted")
+	}
+	if len(args.Peer) != 0 {
+		client := http.DefaultClient
+		if args.PeerInsecure {
+			client = &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				},
+			}
+		}
+		s.peers = newPeers(c, peersOpts{
+			Peers:     args.Peer,
+			Self:      args.Self,
+			Token:     args.PeerToken,
+			KeepLocal: args.KeepLocal,
+			Client:    client,
 		})
-	})
+		log.Printf("fetching misses from %d peers", len(args.Peer))
+	}
+	mux := s.handler()
+	mux.Handle("/metrics", s.adminOnly(promhttp.Handler()))
 	cert, err := missinggo.NewSelfSignedCertificate()
 	if err != nil {
 		log.Fatal(err)
 	}
 	srv := http.Server{
-		Addr: args.Addr,
+		Addr:    args.Addr,
+		Handler: mux,
 		TLSConfig: &tls.Config{
 			Certificates: []tls.Certificate{cert},
 		},
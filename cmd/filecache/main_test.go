@@ -1,9 +1,157 @@
 package main
 
 import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/missinggo/v2/filecache"
+	"github.com/anacrolix/missinggo/v2/httpfile"
 )
 
+func newTestServer(t *testing.T) (*server, *httptest.Server) {
+	c, err := filecache.NewCache(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	s := &server{c: c}
+	hs := httptest.NewServer(s.handler())
+	t.Cleanup(hs.Close)
+	return s, hs
+}
+
+func doRequest(t *testing.T, method, url, body string, header http.Header) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
 func TestFailedPutDeletesFile(t *testing.T) {
-	// TODO
+	s, hs := newTestServer(t)
+	resp := doRequest(t, "PATCH", hs.URL+"/a", "hello", http.Header{
+		"Content-Range": {"bytes 0-4/10"},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	// The client claims a different body length to the one it sends. The body is wrapped so it's
+	// sent without a Content-Length.
+	req, err := http.NewRequest("PATCH", hs.URL+"/a", io.MultiReader(strings.NewReader("wor")))
+	require.NoError(t, err)
+	req.Header.Set("Content-Range", "bytes 5-9/10")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, ok := s.c.Item("a")
+	assert.False(t, ok)
+}
+
+func TestContentRangeValidation(t *testing.T) {
+	_, hs := newTestServer(t)
+	for _, _case := range []struct {
+		contentRange string
+		body         string
+		status       int
+	}{
+		{"bytes 0-4/5", "hello", http.StatusOK},
+		{"bytes 0-4/*", "hello", http.StatusOK},
+		{"bytes 0-4/4", "hello", http.StatusRequestedRangeNotSatisfiable},
+		{"bytes 0-5/10", "hello", http.StatusBadRequest},
+		{"bytes 4-0/10", "hello", http.StatusBadRequest},
+		{"bytes */10", "hello", http.StatusBadRequest},
+		{"bytes 0-", "hello", http.StatusBadRequest},
+	} {
+		resp := doRequest(t, "PUT", hs.URL+"/a", _case.body, http.Header{
+			"Content-Range": {_case.contentRange},
+		})
+		assert.Equal(t, _case.status, resp.StatusCode, _case.contentRange)
+	}
+}
+
+func TestETags(t *testing.T) {
+	_, hs := newTestServer(t)
+	resp := doRequest(t, "PUT", hs.URL+"/a", "hello", http.Header{"If-None-Match": {"*"}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	// Create-only fails now that it exists.
+	resp = doRequest(t, "PUT", hs.URL+"/a", "world", http.Header{"If-None-Match": {"*"}})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = doRequest(t, "GET", hs.URL+"/a", "", nil)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	resp = doRequest(t, "GET", hs.URL+"/a", "", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// If-None-Match uses weak comparison, and If-Match strong.
+	resp = doRequest(t, "PUT", hs.URL+"/a", "world", http.Header{"If-None-Match": {"W/" + etag}})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp = doRequest(t, "PUT", hs.URL+"/a", "world", http.Header{"If-Match": {"W/" + etag}})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = doRequest(t, "PUT", hs.URL+"/a", "world", http.Header{"If-Match": {etag}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	newETag := resp.Header.Get("ETag")
+	assert.NotEqual(t, etag, newETag)
+	// A write based on the old version is refused.
+	resp = doRequest(t, "PUT", hs.URL+"/a", "herp", http.Header{"If-Match": {etag}})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp = doRequest(t, "DELETE", hs.URL+"/a", "", http.Header{"If-Match": {etag}})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp = doRequest(t, "DELETE", hs.URL+"/a", "", http.Header{"If-Match": {newETag}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doRequest(t, "PUT", hs.URL+"/a", "herp", http.Header{"If-Match": {"*"}})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+}
+
+// A PUT that stops short of the complete length it declares doesn't make a complete item.
+func TestPutShortOfLength(t *testing.T) {
+	s, hs := newTestServer(t)
+	resp := doRequest(t, "PUT", hs.URL+"/a", "some old contents", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doRequest(t, "PUT", hs.URL+"/a", "hello", http.Header{
+		"Content-Range": {"bytes 0-4/10"},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	ii, ok := s.c.Item("a")
+	require.True(t, ok)
+	assert.EqualValues(t, 10, ii.Size)
+	assert.False(t, ii.IsComplete())
+	resp = doRequest(t, "HEAD", hs.URL+"/a", "", nil)
+	assert.Equal(t, "bytes=0-4", resp.Header.Get(availableRangesHeader))
+	assert.Equal(t, "5", resp.Header.Get(httpfile.UploadOffsetHeader))
+	resp = doRequest(t, "GET", hs.URL+"/a", "", http.Header{"Range": {"bytes=0-4"}})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "hello", readBody(t, resp))
+}
+
+func TestHeadAvailableRanges(t *testing.T) {
+	_, hs := newTestServer(t)
+	resp := doRequest(t, "PATCH", hs.URL+"/a", "world", http.Header{
+		"Content-Range": {"bytes 10-14/20"},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doRequest(t, "HEAD", hs.URL+"/a", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "bytes=10-14", resp.Header.Get(availableRangesHeader))
+	resp = doRequest(t, "PATCH", hs.URL+"/a", "hello", http.Header{
+		"Content-Range": {"bytes 0-4/20"},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doRequest(t, "HEAD", hs.URL+"/a", "", nil)
+	assert.Equal(t, "bytes=0-4,10-14", resp.Header.Get(availableRangesHeader))
+	resp = doRequest(t, "GET", hs.URL+"/a", "", http.Header{"Range": {"bytes=10-14"}})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(b))
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/missinggo/v2/filecache"
+	"github.com/anacrolix/missinggo/v2/resource"
+)
+
+// Set on requests between peers. A node doesn't forward misses for requests that carry it, so
+// nodes with inconsistent peer lists can't bounce a request between them.
+const peerRequestHeader = "X-Filecache-Peer"
+
+// Points on the ring per peer. More spreads keys more evenly between peers.
+const ringReplicas = 100
+
+type ringPoint struct {
+	hash uint32
+	peer string
+}
+
+// Assigns keys to peers by consistent hashing, so adding or removing a peer only moves the keys it
+// gains or loses.
+type hashRing struct {
+	points []ringPoint
+}
+
+func newHashRing(peers []string) *hashRing {
+	ret := &hashRing{}
+	for _, p := range peers {
+		for i := 0; i < ringReplicas; i++ {
+			ret.points = append(ret.points, ringPoint{
+				hash: crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s#%d", p, i))),
+				peer: p,
+			})
+		}
+	}
+	sort.Slice(ret.points, func(i, j int) bool {
+		l, r := ret.points[i], ret.points[j]
+		if l.hash != r.hash {
+			return l.hash < r.hash
+		}
+		return l.peer < r.peer
+	})
+	return ret
+}
+
+// Returns the peer that owns the key, or "" if there are no peers.
+func (me *hashRing) owner(key string) string {
+	if len(me.points) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(me.points), func(i int) bool { return me.points[i].hash >= h })
+	if i == len(me.points) {
+		i = 0
+	}
+	return me.points[i].peer
+}
+
+type peersOpts struct {
+	// Base URLs of the filecache servers that share keys, which may include this one.
+	Peers []string
+	// This server's base URL as it appears in Peers. Misses for keys it owns aren't forwarded.
+	Self string
+	// Sent as a bearer token to peers.
+	Token string
+	// Keep a copy of items fetched from peers in the local cache.
+	KeepLocal bool
+	// Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Fetches items this node doesn't have from the peer that owns them.
+type peers struct {
+	self      string
+	bases     []string
+	ring      *hashRing
+	keepLocal bool
+	client    *http.Client
+	local     *filecache.ReadThroughProvider
+}
+
+func newPeers(c *filecache.Cache, opts peersOpts) *peers {
+	bases := make([]string, 0, len(opts.Peers))
+	for _, p := range opts.Peers {
+		bases = append(bases, strings.TrimSuffix(p, "/"))
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	client = &http.Client{
+		Transport: &peerTransport{
+			token: opts.Token,
+			rt:    client.Transport,
+		},
+		CheckRedirect: client.CheckRedirect,
+		Jar:           client.Jar,
+		Timeout:       client.Timeout,
+	}
+	ret := &peers{
+		self:      strings.TrimSuffix(opts.Self, "/"),
+		bases:     bases,
+		ring:      newHashRing(bases),
+		keepLocal: opts.KeepLocal,
+		client:    client,
+	}
+	ret.local = &filecache.ReadThroughProvider{
+		Cache:     c,
+		Upstream:  &resource.HTTPProvider{Client: client},
+		CachePath: ret.cachePath,
+	}
+	return ret
+}
+
+// Returns the URL of the item at path on the peer that owns it, or false if this node is the owner.
+func (me *peers) ownerURL(path string) (string, bool) {
+	owner := me.ring.owner(path)
+	if owner == "" || owner == me.self {
+		return "", false
+	}
+	return owner + (&url.URL{Path: "/" + path}).EscapedPath(), true
+}
+
+// Maps an item URL on a peer back to the item's path.
+func (me *peers) cachePath(loc string) string {
+	for _, b := range me.bases {
+		if strings.HasPrefix(loc, b+"/") {
+			loc = loc[len(b):]
+			break
+		}
+	}
+	if p, err := url.PathUnescape(loc); err == nil {
+		loc = p
+	}
+	return strings.TrimPrefix(loc, "/")
+}
+
+// Adds the peer header and credentials to requests.
+type peerTransport struct {
+	token string
+	rt    http.RoundTripper
+}
+
+func (me *peerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set(peerRequestHeader, "1")
+	if me.token != "" {
+		r.Header.Set("Authorization", "Bearer "+me.token)
+	}
+	rt := me.rt
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return rt.RoundTrip(r)
+}
+
+// Handles a GET or HEAD for an item that isn't in the local cache. Returns false if the item
+// should be treated as missing.
+func (me *server) servePeer(w http.ResponseWriter, r *http.Request, path string) bool {
+	if me.peers == nil || r.Header.Get(peerRequestHeader) != "" {
+		return false
+	}
+	u, ok := me.peers.ownerURL(path)
+	if !ok {
+		return false
+	}
+	var err error
+	if me.peers.keepLocal && r.Method == "GET" {
+		err = me.fillFromPeer(u)
+		if err == nil {
+			me.serveLocal(w, r, path)
+			return true
+		}
+	} else {
+		err = me.streamFromPeer(w, r, u)
+		if err == nil {
+			return true
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	log.Printf("error fetching %q from peer: %s", u, err)
+	http.Error(w, "error fetching from peer", http.StatusBadGateway)
+	return true
+}
+
+// Copies the item at the peer URL into the local cache.
+func (me *server) fillFromPeer(u string) error {
+	i, err := me.peers.local.NewInstance(u)
+	if err != nil {
+		return err
+	}
+	rc, err := i.Get()
+	if err != nil {
+		return err
+	}
+	return rc.Close()
+}
+
+// Request headers passed on to the owner when streaming from it, so it handles ranges and
+// conditional requests.
+var forwardedRequestHeaders = []string{
+	"Range",
+	"If-Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+}
+
+// Response headers from the owner passed back to the client.
+var forwardedResponseHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Range",
+	"Accept-Ranges",
+	"ETag",
+	"Last-Modified",
+	availableRangesHeader,
+}
+
+// Serves the item at the peer URL without keeping it, with a single request to the owner.
+func (me *server) streamFromPeer(w http.ResponseWriter, r *http.Request, u string) error {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, u, nil)
+	if err != nil {
+		return err
+	}
+	for _, h := range forwardedRequestHeaders {
+		if v := r.Header.Values(h); len(v) != 0 {
+			req.Header[h] = v
+		}
+	}
+	resp, err := me.peers.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK,
+		http.StatusPartialContent,
+		http.StatusNotModified,
+		http.StatusPreconditionFailed,
+		http.StatusRequestedRangeNotSatisfiable:
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	default:
+		return errors.New(resp.Status)
+	}
+	for _, h := range forwardedResponseHeaders {
+		if v := resp.Header.Values(h); len(v) != 0 {
+			w.Header()[h] = v
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		// It's too late to tell the client, other than by cutting the response short.
+		log.Printf("error streaming %q from peer: %v", u, err)
+	}
+	return nil
+}
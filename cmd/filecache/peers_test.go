@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashRing(t *testing.T) {
+	peers := []string{"http://a", "http://b", "http://c"}
+	r := newHashRing(peers)
+	smaller := newHashRing(peers[:2])
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		k := fmt.Sprintf("key%d", i)
+		o := r.owner(k)
+		counts[o]++
+		if o != "http://c" {
+			// Removing a peer only moves the keys it owned.
+			assert.Equal(t, o, smaller.owner(k))
+		}
+	}
+	for _, p := range peers {
+		assert.Greater(t, counts[p], 500, p)
+	}
+	assert.Equal(t, "", newHashRing(nil).owner("a"))
+}
+
+// Starts servers that all have each other as peers.
+func newTestPeers(t *testing.T, n int, keepLocal bool) (ss []*server, hss []*httptest.Server) {
+	var urls []string
+	for i := 0; i < n; i++ {
+		s, hs := newTestServer(t)
+		ss = append(ss, s)
+		hss = append(hss, hs)
+		urls = append(urls, hs.URL)
+	}
+	for i, s := range ss {
+		s.peers = newPeers(s.c, peersOpts{
+			Peers:     urls,
+			Self:      urls[i],
+			KeepLocal: keepLocal,
+		})
+	}
+	return
+}
+
+// Returns the index of the server that owns path, and of one that doesn't.
+func ownerAndOther(ss []*server, hss []*httptest.Server, path string) (owner, other int) {
+	o := ss[0].peers.ring.owner(path)
+	for i, hs := range hss {
+		if hs.URL == o {
+			owner = i
+		}
+	}
+	return owner, (owner + 1) % len(ss)
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestPeerFetch(t *testing.T) {
+	ss, hss := newTestPeers(t, 3, false)
+	const path = "some/item"
+	owner, other := ownerAndOther(ss, hss, path)
+	resp := doRequest(t, "PUT", hss[owner].URL+"/"+path, "hello world", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doRequest(t, "GET", hss[other].URL+"/"+path, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello world", readBody(t, resp))
+	resp = doRequest(t, "GET", hss[other].URL+"/"+path, "", http.Header{"Range": {"bytes=6-"}})
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "world", readBody(t, resp))
+	_, ok := ss[other].c.Item(path)
+	assert.False(t, ok)
+	resp = doRequest(t, "GET", hss[other].URL+"/missing", "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	// Peers don't forward requests from other peers.
+	resp = doRequest(t, "GET", hss[other].URL+"/"+path, "", http.Header{peerRequestHeader: {"1"}})
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestPeerFetchKeepLocal(t *testing.T) {
+	ss, hss := newTestPeers(t, 2, true)
+	const path = "a"
+	owner, other := ownerAndOther(ss, hss, path)
+	resp := doRequest(t, "PUT", hss[owner].URL+"/"+path, "hello", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doRequest(t, "GET", hss[other].URL+"/"+path, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", readBody(t, resp))
+	ii, ok := ss[other].c.Item(path)
+	require.True(t, ok)
+	assert.EqualValues(t, 5, ii.Size)
+	// The local copy is served even once the owner no longer has it.
+	resp = doRequest(t, "DELETE", hss[owner].URL+"/"+path, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doRequest(t, "GET", hss[other].URL+"/"+path, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", readBody(t, resp))
+}
+
+func TestPeerFetchConditional(t *testing.T) {
+	ss, hss := newTestPeers(t, 2, false)
+	const path = "a"
+	owner, other := ownerAndOther(ss, hss, path)
+	resp := doRequest(t, "PUT", hss[owner].URL+"/"+path, "hello world", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var ownerRequests atomic.Int32
+	h := hss[owner].Config.Handler
+	hss[owner].Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ownerRequests.Add(1)
+		h.ServeHTTP(w, r)
+	})
+	resp = doRequest(t, "GET", hss[other].URL+"/"+path, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello world", readBody(t, resp))
+	assert.EqualValues(t, 1, ownerRequests.Load())
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.NotEmpty(t, resp.Header.Get("Last-Modified"))
+	resp = doRequest(t, "GET", hss[other].URL+"/"+path, "", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	resp = doRequest(t, "GET", hss[other].URL+"/"+path, "", http.Header{
+		"Range":    {"bytes=6-"},
+		"If-Range": {etag},
+	})
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "bytes 6-10/11", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "world", readBody(t, resp))
+	resp = doRequest(t, "GET", hss[other].URL+"/"+path, "", http.Header{
+		"Range":    {"bytes=6-"},
+		"If-Range": {`"stale"`},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello world", readBody(t, resp))
+	assert.EqualValues(t, 4, ownerRequests.Load())
+}
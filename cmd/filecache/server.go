@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/missinggo/v2"
+	"github.com/anacrolix/missinggo/v2/filecache"
+	"github.com/anacrolix/missinggo/v2/httpfile"
+	"github.com/anacrolix/missinggo/v2/httptoo"
+)
+
+// Lists the byte ranges of an item that are present, in Range header syntax. It's set on GET and
+// HEAD responses.
+const availableRangesHeader = "X-Available-Ranges"
+
+type server struct {
+	c *filecache.Cache
+	// Nil if access isn't restricted.
+	tokens *tokens
+	// Serializes writes to each path, so preconditions hold until the write is done.
+	sf missinggo.SingleFlight
+	// Nil if misses aren't fetched from peers.
+	peers *peers
+}
+
+func (me *server) handler() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", me.serveItem)
+	mux.Handle("/status", me.adminOnly(http.HandlerFunc(me.serveStatus)))
+	mux.Handle("/lru", me.adminOnly(http.HandlerFunc(me.serveLru)))
+	mux.Handle("/admin/", me.adminHandler())
+	return mux
+}
+
+func (me *server) serveItem(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Path[1:]
+	if !me.authorized(w, r, methodPermissions(r.Method), p) {
+		return
+	}
+	switch r.Method {
+	case "DELETE":
+		log.Printf("%s %s", r.Method, r.RequestURI)
+		me.handleDelete(w, r, p)
+	case "PUT", "PATCH", "POST":
+		log.Printf("%s %q %s", r.Method, r.Header.Get("Content-Range"), r.RequestURI)
+		me.handleNewData(w, r, p)
+	default:
+		log.Printf("%s %s %s", r.Method, r.Header.Get("Range"), r.RequestURI)
+		me.handleGet(w, r, p)
+	}
+}
+
+func methodPermissions(method string) permissions {
+	switch method {
+	case "DELETE":
+		return permDelete
+	case "PUT", "PATCH", "POST":
+		return permWrite
+	default:
+		return permRead
+	}
+}
+
+// Items with a content hash get it as a strong ETag. Otherwise it's derived from the size and
+// modification time.
+func itemETag(ii filecache.ItemInfo, fi os.FileInfo) string {
+	if ii.Hash != "" {
+		return `"` + ii.Hash + `"`
+	}
+	return fmt.Sprintf(`"%x-%x"`, fi.Size(), fi.ModTime().UnixNano())
+}
+
+// Returns the ETag of the item at path, or "" if there isn't one.
+func (me *server) etag(path string) string {
+	ii, ok := me.c.Item(path)
+	if !ok {
+		return ""
+	}
+	fi, err := me.c.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return itemETag(ii, fi)
+}
+
+func formatAvailableRanges(ii filecache.ItemInfo) string {
+	if ii.IsComplete() {
+		if ii.Size == 0 {
+			return ""
+		}
+		return fmt.Sprintf("bytes=0-%d", ii.Size-1)
+	}
+	ss := make([]string, 0, len(ii.Completed))
+	for _, r := range ii.Completed {
+		ss = append(ss, fmt.Sprintf("%d-%d", r.Start, r.End-1))
+	}
+	if len(ss) == 0 {
+		return ""
+	}
+	return "bytes=" + strings.Join(ss, ",")
+}
+
+func (me *server) handleGet(w http.ResponseWriter, r *http.Request, p string) {
+	if _, ok := me.c.Item(p); !ok && me.servePeer(w, r, p) {
+		return
+	}
+	me.serveLocal(w, r, p)
+}
+
+func (me *server) serveLocal(w http.ResponseWriter, r *http.Request, p string) {
+	f, err := me.c.OpenFile(p, os.O_RDONLY)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("couldn't open requested file: %s", err)
+		http.Error(w, "couldn't open file", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		go f.Close()
+	}()
+	info, _ := f.Stat()
+	w.Header().Set("Content-Range", fmt.Sprintf("*/%d", info.Size()))
+	if ii, ok := me.c.Item(p); ok {
+		// ServeContent handles the conditional headers given the ETag.
+		w.Header().Set("ETag", itemETag(ii, info))
+		if ranges := formatAvailableRanges(ii); ranges != "" {
+			w.Header().Set(availableRangesHeader, ranges)
+		}
+		w.Header().Set(httpfile.UploadOffsetHeader, strconv.FormatInt(uploadOffset(ii), 10))
+	}
+	http.ServeContent(w, r, p, info.ModTime(), f)
+}
+
+// Returns whether any of the ETags in the header value match etag. "*" matches any existing item.
+// Weak comparison ignores W/ prefixes, and strong comparison never matches weak ETags.
+func etagListMatches(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, s := range strings.Split(header, ",") {
+		s = strings.TrimSpace(s)
+		if s == "*" {
+			return true
+		}
+		if weak {
+			if strings.TrimPrefix(s, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if s == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks If-Match and If-None-Match for a request that modifies the item with the given ETag,
+// which is empty if the item doesn't exist.
+func writePreconditionsHold(r *http.Request, etag string) bool {
+	if im := r.Header.Get("If-Match"); im != "" && !etagListMatches(im, etag, false) {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagListMatches(inm, etag, true) {
+		return false
+	}
+	return true
+}
+
+var (
+	errBadContentRange         = errors.New("bad Content-Range")
+	errContentRangeUnsatisfied = errors.New("Content-Range exceeds the complete length")
+)
+
+// Returns the range a request body is for. Without a Content-Range, it's the start of the item,
+// with the Last and Length fields set to -1 where they're unknown.
+func uploadRange(r *http.Request) (ret httptoo.BytesContentRange, err error) {
+	h := r.Header.Get("Content-Range")
+	if h == "" {
+		return httptoo.BytesContentRange{First: 0, Last: r.ContentLength - 1, Length: -1}, nil
+	}
+	ret, ok := httptoo.ParseBytesContentRange(h)
+	if !ok || ret.First < 0 || ret.Last < ret.First {
+		err = errBadContentRange
+		return
+	}
+	if ret.Length >= 0 && ret.Last >= ret.Length {
+		err = errContentRangeUnsatisfied
+		return
+	}
+	if r.ContentLength >= 0 && r.ContentLength != ret.Last-ret.First+1 {
+		err = fmt.Errorf("%w: doesn't match Content-Length", errBadContentRange)
+	}
+	return
+}
+
+// Returns how much of the item from the start has been written, which is where a resumable upload
+// continues from.
+func uploadOffset(ii filecache.ItemInfo) int64 {
+	if ii.IsComplete() {
+		return ii.Size
+	}
+	if len(ii.Completed) != 0 && ii.Completed[0].Start == 0 {
+		return ii.Completed[0].End
+	}
+	return 0
+}
+
+// Returns the offset a resumable upload request claims to continue from, or -1 if it's not part of
+// one.
+func requestUploadOffset(r *http.Request) (int64, error) {
+	h := r.Header.Get(httpfile.UploadOffsetHeader)
+	if h == "" {
+		return -1, nil
+	}
+	off, err := strconv.ParseInt(h, 10, 64)
+	if err != nil || off < 0 {
+		return -1, fmt.Errorf("bad %s", httpfile.UploadOffsetHeader)
+	}
+	return off, nil
+}
+
+// A PUT of a whole item replaces it atomically, so a failed upload leaves any previous item intact.
+// A PUT from the start of an item that stops short of its complete length replaces the item too,
+// but not atomically. Other writes go directly into the item, and a failure removes it, except for
+// writes that are part of a resumable upload. A complete length beyond what's been written leaves a
+// hole up to it, so the item isn't taken as complete. Those must continue from the end of what's been written, and a failure
+// keeps what was received so the client can resume from there.
+func (me *server) handleNewData(w http.ResponseWriter, r *http.Request, path string) {
+	cr, err := uploadRange(r)
+	if err == nil {
+		var resumeOff int64
+		resumeOff, err = requestUploadOffset(r)
+		if err == nil && resumeOff >= 0 && resumeOff != cr.First {
+			err = fmt.Errorf("%w: doesn't match %s", errBadContentRange, httpfile.UploadOffsetHeader)
+		}
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errContentRangeUnsatisfied) {
+			status = http.StatusRequestedRangeNotSatisfiable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer me.sf.Lock(path).Unlock()
+	if !writePreconditionsHold(r, me.etag(path)) {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	resumable := r.Header.Get(httpfile.UploadOffsetHeader) != ""
+	if resumable {
+		var off int64
+		if ii, ok := me.c.Item(path); ok {
+			off = uploadOffset(ii)
+		}
+		if off != cr.First {
+			w.Header().Set(httpfile.UploadOffsetHeader, strconv.FormatInt(off, 10))
+			http.Error(w, "upload offset doesn't match", http.StatusConflict)
+			return
+		}
+	}
+	replace := r.Method == "PUT" && cr.First == 0 && !resumable
+	atomic := replace && (cr.Length < 0 || cr.Last+1 == cr.Length)
+	if ii, ok := me.c.Item(path); ok && !replace && cr.Length >= 0 && ii.Size > cr.Length {
+		http.Error(w, "item is longer than the Content-Range complete length", http.StatusConflict)
+		return
+	}
+	flag := os.O_CREATE | os.O_WRONLY
+	if replace {
+		flag |= os.O_TRUNC
+	}
+	f, err := me.c.OpenFileOpts(path, flag, filecache.OpenOpts{Atomic: atomic})
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "couldn't open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	f.Seek(cr.First, io.SeekStart)
+	body := io.Reader(r.Body)
+	if cr.Last >= 0 {
+		// Read one byte more than expected, to catch overlong bodies.
+		body = io.LimitReader(body, cr.Last-cr.First+2)
+	}
+	n, err := io.Copy(f, body)
+	if err == nil && cr.Last >= 0 && n != cr.Last-cr.First+1 {
+		err = fmt.Errorf("%w: got %d bytes", errBadContentRange, n)
+	}
+	if err == nil && cr.Length > cr.Last+1 {
+		var fi os.FileInfo
+		fi, err = f.Stat()
+		if err == nil && fi.Size() < cr.Length {
+			err = f.Truncate(cr.Length)
+		}
+	}
+	if err == nil {
+		err = f.Commit()
+	}
+	if err != nil {
+		log.Print(err)
+		if !atomic && !resumable {
+			me.c.Remove(path)
+		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, errBadContentRange) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, "didn't complete", status)
+		return
+	}
+	if ii, ok := me.c.Item(path); ok {
+		w.Header().Set(httpfile.UploadOffsetHeader, strconv.FormatInt(uploadOffset(ii), 10))
+	}
+	if etag := me.etag(path); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+func (me *server) handleDelete(w http.ResponseWriter, r *http.Request, path string) {
+	defer me.sf.Lock(path).Unlock()
+	if !writePreconditionsHold(r, me.etag(path)) {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	err := me.c.Remove(path)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "didn't work", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (me *server) serveStatus(w http.ResponseWriter, r *http.Request) {
+	info := me.c.Info()
+	fmt.Fprintf(w, "Capacity: %d\n", info.Capacity)
+	fmt.Fprintf(w, "Current Size: %d\n", info.Filled)
+	fmt.Fprintf(w, "Item Count: %d\n", info.NumItems)
+	fmt.Fprintf(w, "Pinned Items: %d (%d bytes)\n", info.NumPinned, info.PinnedFilled)
+	for ns, q := range info.Quotas {
+		fmt.Fprintf(w, "Quota %q: %d/%d\n", ns, q.Filled, q.Quota)
+	}
+}
+
+func (me *server) serveLru(w http.ResponseWriter, r *http.Request) {
+	me.c.WalkItems(func(item filecache.ItemInfo) {
+		pinned := ""
+		if item.Pinned {
+			pinned = "\tpinned"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s%s\n", item.Accessed, item.Size, item.Path, pinned)
+	})
+}
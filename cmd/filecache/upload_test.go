@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/missinggo/v2/httpfile"
+)
+
+// Drops the connection part way through the bodies of the next few PATCH requests.
+type droppingTransport struct {
+	mu    sync.Mutex
+	drops int
+	after int64
+	rt    http.RoundTripper
+}
+
+func (me *droppingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	me.mu.Lock()
+	drop := r.Method == "PATCH" && me.drops > 0
+	if drop {
+		me.drops--
+	}
+	me.mu.Unlock()
+	if drop {
+		r = r.Clone(r.Context())
+		r.Body = io.NopCloser(io.MultiReader(
+			io.LimitReader(r.Body, me.after),
+			errReader{errors.New("dropped")},
+		))
+	}
+	return me.rt.RoundTrip(r)
+}
+
+type errReader struct {
+	err error
+}
+
+func (me errReader) Read([]byte) (int, error) {
+	return 0, me.err
+}
+
+func newUploadFS(t *testing.T, drops int, after int64) (*server, string, *httpfile.FS) {
+	s, hs := newTestServer(t)
+	return s, hs.URL, &httpfile.FS{
+		Client: &http.Client{Transport: &droppingTransport{
+			drops: drops,
+			after: after,
+			rt:    hs.Client().Transport,
+		}},
+		RetryBackoff: time.Millisecond,
+	}
+}
+
+func getBody(t *testing.T, url string) []byte {
+	resp := doRequest(t, "GET", url, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return b
+}
+
+func TestResumableFileWrites(t *testing.T) {
+	_, url, fs := newUploadFS(t, 1, 3)
+	f, err := fs.OpenResumable(url + "/a")
+	require.NoError(t, err)
+	for _, s := range []string{"hello", ", ", "world"} {
+		n, err := io.WriteString(f, s)
+		require.NoError(t, err)
+		assert.Equal(t, len(s), n)
+	}
+	require.NoError(t, f.Close())
+	assert.Zero(t, fs.Client.Transport.(*droppingTransport).drops)
+	assert.Equal(t, "hello, world", string(getBody(t, url+"/a")))
+	off, err := fs.UploadOffset(url + "/a")
+	require.NoError(t, err)
+	assert.EqualValues(t, 12, off)
+	off, err = fs.UploadOffset(url + "/missing")
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, off)
+	// Continuing the upload starts from the end.
+	f, err = fs.OpenResumable(url + "/a")
+	require.NoError(t, err)
+	_, err = io.WriteString(f, "!")
+	require.NoError(t, err)
+	f.Close()
+	assert.Equal(t, "hello, world!", string(getBody(t, url+"/a")))
+}
+
+// Ordinary File writes can go anywhere in an existing item.
+func TestFileRandomAccessWrites(t *testing.T) {
+	_, url, fs := newUploadFS(t, 0, 0)
+	resp := doRequest(t, "PUT", url+"/a", "hello world", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	f, err := fs.Open(url+"/a", os.O_WRONLY|os.O_CREATE)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.Seek(6, io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.WriteString(f, "WORLD")
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.WriteString(f, "HELLO")
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO WORLD", string(getBody(t, url+"/a")))
+}
+
+func TestUploadSurvivesDrops(t *testing.T) {
+	s, url, fs := newUploadFS(t, 5, 10000)
+	content := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(content)
+	// Some of it is there already from an earlier attempt.
+	resp := doRequest(t, "PATCH", url+"/big", string(content[:100000]), http.Header{
+		"Content-Range": {"bytes 0-99999/*"},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	err := fs.Upload(url+"/big", bytes.NewReader(content), int64(len(content)), 64<<10)
+	require.NoError(t, err)
+	assert.Zero(t, fs.Client.Transport.(*droppingTransport).drops)
+	assert.True(t, bytes.Equal(content, getBody(t, url+"/big")))
+	ii, ok := s.c.Item("big")
+	require.True(t, ok)
+	assert.True(t, ii.IsComplete())
+}
+
+func TestUploadOffsetMismatch(t *testing.T) {
+	_, hs := newTestServer(t)
+	resp := doRequest(t, "PATCH", hs.URL+"/a", "hello", http.Header{
+		"Content-Range":             {"bytes 0-4/*"},
+		httpfile.UploadOffsetHeader: {"0"},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get(httpfile.UploadOffsetHeader))
+	resp = doRequest(t, "PATCH", hs.URL+"/a", "hello", http.Header{
+		"Content-Range":             {"bytes 3-7/*"},
+		httpfile.UploadOffsetHeader: {"3"},
+	})
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get(httpfile.UploadOffsetHeader))
+	resp = doRequest(t, "PATCH", hs.URL+"/a", "hello", http.Header{
+		"Content-Range":             {"bytes 5-9/*"},
+		httpfile.UploadOffsetHeader: {"4"},
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = doRequest(t, "HEAD", hs.URL+"/a", "", nil)
+	assert.Equal(t, "5", resp.Header.Get(httpfile.UploadOffsetHeader))
+}
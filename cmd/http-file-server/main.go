@@ -7,6 +7,8 @@ import (
 	"os"
 
 	"github.com/anacrolix/tagflag"
+
+	"github.com/anacrolix/missinggo/v2/httpfile"
 )
 
 func setIfGetHeader(w http.ResponseWriter, r *http.Request, set, get string) {
@@ -64,5 +66,7 @@ func main() {
 	defer l.Close()
 	addr := l.Addr()
 	log.Printf("serving %q at %s", dir, addr)
-	log.Fatal(http.Serve(l, logAccess(allowCORS(http.FileServer(http.Dir(dir))))))
+	root := http.Dir(dir)
+	h := httpfile.PropfindHandler(root, http.FileServer(root))
+	log.Fatal(http.Serve(l, logAccess(allowCORS(h))))
 }
@@ -0,0 +1,137 @@
+package filecache
+
+import (
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/missinggo/v2/pproffd"
+)
+
+// Files opened for writing with OpenOpts.Atomic write to a staging file in the meta directory. The
+// item is only replaced when the file is committed, so a crash or failed write leaves any previous
+// item intact. Staging files left behind are removed when the cache starts.
+
+func (me *Cache) tmpDir() string {
+	return filepath.Join(me.metaDir(), tmpDirName)
+}
+
+// Removes staging files left over from a previous run.
+func (me *Cache) cleanTmpDir() {
+	err := os.RemoveAll(me.tmpDir())
+	if err != nil {
+		log.Printf("error cleaning staging files: %v", err)
+	}
+}
+
+func (me *Cache) openStaged(k key, flag int, opts OpenOpts) (ret *File, err error) {
+	filePath := me.realpath(k)
+	if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		if _, err = os.Stat(filePath); err == nil {
+			err = &fs.PathError{Op: "open", Path: filePath, Err: fs.ErrExist}
+			return
+		}
+	}
+	var src *os.File
+	if flag&os.O_TRUNC == 0 || flag&os.O_CREATE == 0 {
+		src, err = os.Open(filePath)
+		if err != nil && !(os.IsNotExist(err) && flag&os.O_CREATE != 0) {
+			return
+		}
+		err = nil
+	}
+	tmp, err := me.tmpFile()
+	if err != nil {
+		if src != nil {
+			src.Close()
+		}
+		return
+	}
+	if src != nil {
+		if flag&os.O_TRUNC == 0 {
+			_, err = io.Copy(tmp, src)
+		}
+		src.Close()
+		if err == nil && flag&os.O_APPEND == 0 {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return
+		}
+	}
+	ret = &File{
+		path: k,
+		f:    pproffd.WrapOSFile(tmp),
+		onRead: func(n int) {
+			me.stats.bytesRead.Add(int64(n))
+		},
+		afterWrite: func(_ int64, n int) {
+			me.stats.bytesWritten.Add(int64(n))
+		},
+		afterTruncate: func(int64) {},
+		completedFrom: func(int64) int64 { return math.MaxInt64 },
+		afterClose: func(bool) {
+			os.Remove(tmp.Name())
+		},
+	}
+	ret.commit = func() (err error) {
+		err = tmp.Sync()
+		if err == nil {
+			err = ret.f.Close()
+		} else {
+			ret.f.Close()
+		}
+		if err == nil {
+			err = me.commitStaged(k, tmp.Name(), opts)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+		return
+	}
+	return
+}
+
+// Moves a staging file into place as the item.
+func (me *Cache) commitStaged(k key, tmpPath string, opts OpenOpts) (err error) {
+	filePath := me.realpath(k)
+	me.mu.Lock()
+	for {
+		err = os.MkdirAll(filepath.Dir(filePath), dirPerm)
+		if isMissingDir(err) {
+			continue
+		} else if err != nil {
+			break
+		}
+		err = os.Rename(tmpPath, filePath)
+		if isMissingDir(err) {
+			continue
+		}
+		break
+	}
+	if err == nil {
+		me.useItem(k, func(i *itemState, ok bool) bool {
+			if ok {
+				ok = me.restatKey(k, i)
+				i.Completed = nil
+			} else {
+				*i, ok = me.statNewKey(k)
+			}
+			me.applyOpenOpts(i, opts)
+			i.Accessed = time.Now()
+			return ok
+		})
+	}
+	me.mu.Unlock()
+	if err == nil && me.opts.Dedup {
+		me.dedup(k)
+	}
+	return
+}
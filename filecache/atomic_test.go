@@ -0,0 +1,74 @@
+package filecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAtomicWrite(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCache(td)
+	require.NoError(t, err)
+	defer c.Close()
+	writeCacheFile(t, c, "a", "hello")
+
+	// An aborted write leaves the item as it was.
+	f, err := c.OpenFileOpts("a", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, OpenOpts{Atomic: true})
+	require.NoError(t, err)
+	_, err = f.Write([]byte("wor"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	b, err := os.ReadFile(filepath.Join(td, "a"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// Appending copies the existing contents.
+	f, err = c.OpenFileOpts("a", os.O_WRONLY|os.O_APPEND, OpenOpts{Atomic: true})
+	require.NoError(t, err)
+	_, err = f.Write([]byte(" world"))
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, c.Info().Filled)
+	require.NoError(t, f.Commit())
+	b, err = os.ReadFile(filepath.Join(td, "a"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+	assert.EqualValues(t, 11, c.Info().Filled)
+
+	// New items only appear on commit.
+	f, err = c.OpenFileOpts("dir/b", os.O_CREATE|os.O_EXCL|os.O_WRONLY, OpenOpts{Atomic: true})
+	require.NoError(t, err)
+	_, err = f.Write([]byte("herp"))
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(td, "dir", "b"))
+	require.NoError(t, f.Commit())
+	ii, ok := c.Item("dir/b")
+	require.True(t, ok)
+	assert.EqualValues(t, 4, ii.Size)
+	_, err = c.OpenFileOpts("dir/b", os.O_CREATE|os.O_EXCL|os.O_WRONLY, OpenOpts{Atomic: true})
+	assert.True(t, os.IsExist(err), err)
+	_, err = c.OpenFileOpts("c", os.O_WRONLY, OpenOpts{Atomic: true})
+	assert.True(t, os.IsNotExist(err), err)
+}
+
+func TestStagingFilesCleanedOnStart(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCache(td)
+	require.NoError(t, err)
+	f, err := c.OpenFileOpts("a", os.O_CREATE|os.O_WRONLY, OpenOpts{Atomic: true})
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	// Simulate a crash by not closing the file.
+	entries, err := os.ReadDir(c.tmpDir())
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	c, err = NewCache(td)
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Info().NumItems)
+	assert.NoDirExists(t, c.tmpDir())
+}
@@ -3,9 +3,11 @@ package filecache
 import (
 	"errors"
 	"io/fs"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -13,6 +15,7 @@ import (
 	"github.com/anacrolix/log"
 
 	"github.com/anacrolix/missinggo/v2/pproffd"
+	"github.com/anacrolix/missinggo/v2/pubsub"
 	"github.com/anacrolix/missinggo/v2/resource"
 )
 
@@ -21,25 +24,109 @@ const (
 	filePerm = 0644
 )
 
+const (
+	// Directory in the cache root reserved for the cache's own state. It's not walked for items, and
+	// can't be opened through the cache.
+	metaDirName = ".filecache"
+)
+
 type Cache struct {
 	root     string
+	opts     CacheOpts
 	mu       sync.Mutex
 	capacity int64
 	filled   int64
-	policy   Policy
-	items    map[key]itemState
+	// Number and total size of pinned items.
+	pinned       int
+	pinnedFilled int64
+	policy       Policy
+	items        map[key]itemState
+	// Objects by hash, in dedup mode.
+	objects       map[string]*object
+	objectsBySize map[int64][]string
+	// Writable handles open on items, in dedup mode.
+	writers map[key]int
+	// Bytes used per namespace, and the quotas set on them.
+	nsFilled map[string]int64
+	quotas   map[string]*quota
+	index    *index
+	events   pubsub.PubSub[Event]
+	stats    stats
+	descs    *metricDescs
+	closed   bool
+	// The reaper has been started.
+	reaping bool
+	// Closed when the cache is closed, to stop background work.
+	done chan struct{}
+	// Closed when the items loaded from the index have been reconciled against the filesystem, or
+	// immediately if a full rescan was done instead.
+	reconciled chan struct{}
+}
+
+type CacheOpts struct {
+	// Maintain a persistent index of items in the cache root. It's loaded on start instead of
+	// walking the entire tree, which is reconciled in the background. If the index is missing or
+	// corrupt, a full rescan is done as usual.
+	Index bool
+	// Constructs the eviction policy. The default is LRU.
+	Policy func() Policy
+	// Store items with identical contents once. See dedup.go.
+	Dedup bool
+	// Initial quotas by namespace. See SetQuota.
+	Quotas map[string]int64
+	// Defaults for item expiry, see OpenOpts. Zero means items don't expire.
+	TTL     time.Duration
+	MaxIdle time.Duration
+	// How often expired items are removed. Defaults to a minute.
+	ReapInterval time.Duration
 }
 
 type CacheInfo struct {
 	Capacity int64
 	Filled   int64
 	NumItems int
+	// The number of pinned items, and their total size.
+	NumPinned    int
+	PinnedFilled int64
+	// Set if any namespaces have quotas, in which case it includes those namespaces.
+	Quotas map[string]QuotaInfo
 }
 
 type ItemInfo struct {
 	Path     key
 	Accessed time.Time
 	Size     int64
+	// The byte ranges of the item that have been written, in order.
+	Completed []Range
+	// The SHA-256 of the contents in dedup mode, once the item has been deduplicated.
+	Hash   string
+	Pinned bool
+	// When the item expires. The zero Time means never.
+	Expires time.Time
+}
+
+// Returns true if all of the item has been written.
+func (me ItemInfo) IsComplete() bool {
+	switch len(me.Completed) {
+	case 0:
+		return me.Size == 0
+	case 1:
+		return me.Completed[0] == Range{0, me.Size}
+	default:
+		return false
+	}
+}
+
+func (me *Cache) itemInfo(k key, ii itemState) ItemInfo {
+	return ItemInfo{
+		Path:      k,
+		Accessed:  ii.Accessed,
+		Size:      ii.Size,
+		Completed: ii.completedRanges(),
+		Hash:      ii.Hash,
+		Pinned:    ii.Pinned,
+		Expires:   me.expiry(ii),
+	}
 }
 
 // Calls the function for every item known to be in the cache.
@@ -47,12 +134,20 @@ func (me *Cache) WalkItems(cb func(ItemInfo)) {
 	me.mu.Lock()
 	defer me.mu.Unlock()
 	for k, ii := range me.items {
-		cb(ItemInfo{
-			Path:     k,
-			Accessed: ii.Accessed,
-			Size:     ii.Size,
-		})
+		cb(me.itemInfo(k, ii))
+	}
+}
+
+// Returns the info for a single item, if it's known to be in the cache.
+func (me *Cache) Item(path string) (ret ItemInfo, ok bool) {
+	k := sanitizePath(path)
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	ii, ok := me.items[k]
+	if ok {
+		ret = me.itemInfo(k, ii)
 	}
+	return
 }
 
 func (me *Cache) Info() (ret CacheInfo) {
@@ -61,6 +156,17 @@ func (me *Cache) Info() (ret CacheInfo) {
 	ret.Capacity = me.capacity
 	ret.Filled = me.filled
 	ret.NumItems = len(me.items)
+	ret.NumPinned = me.pinned
+	ret.PinnedFilled = me.pinnedFilled
+	for ns, q := range me.quotas {
+		if ret.Quotas == nil {
+			ret.Quotas = make(map[string]QuotaInfo, len(me.quotas))
+		}
+		ret.Quotas[ns] = QuotaInfo{
+			Quota:  q.limit,
+			Filled: me.nsFilled[ns],
+		}
+	}
 	return
 }
 
@@ -72,19 +178,103 @@ func (me *Cache) SetCapacity(capacity int64) {
 }
 
 func NewCache(root string) (ret *Cache, err error) {
+	return NewCacheOpts(root, CacheOpts{})
+}
+
+func NewCacheOpts(root string, opts CacheOpts) (ret *Cache, err error) {
 	root, err = filepath.Abs(root)
 	ret = &Cache{
-		root:     root,
-		capacity: -1, // unlimited
+		root:       root,
+		opts:       opts,
+		capacity:   -1, // unlimited
+		reconciled: make(chan struct{}),
+		done:       make(chan struct{}),
+		descs:      newMetricDescs(root),
 	}
 	ret.mu.Lock()
 	go func() {
 		defer ret.mu.Unlock()
-		ret.rescan()
+		ret.init()
+		ret.startReaperIfNeeded()
 	}()
 	return
 }
 
+func (me *Cache) init() {
+	started := time.Now()
+	defer func() {
+		me.stats.lastInitDurationNanos.Store(int64(time.Since(started)))
+	}()
+	me.cleanTmpDir()
+	me.filled = 0
+	me.pinned = 0
+	me.pinnedFilled = 0
+	me.policy = me.newPolicy()
+	me.items = make(map[key]itemState)
+	me.nsFilled = make(map[string]int64)
+	for ns, limit := range me.opts.Quotas {
+		me.setQuota(ns, limit)
+	}
+	if me.opts.Dedup {
+		me.scanObjects()
+	}
+	if me.opts.Index {
+		err := me.loadIndex()
+		if err == nil {
+			go me.reconcile()
+			return
+		}
+		if !os.IsNotExist(err) {
+			log.Printf("error loading index, rescanning: %v", err)
+		}
+	}
+	me.rescan()
+	me.removeOrphanObjects()
+	close(me.reconciled)
+	if me.opts.Index {
+		var err error
+		me.index, err = writeIndex(me.indexPath(), me.items)
+		if err != nil {
+			me.dropIndex(err)
+		}
+	}
+}
+
+var errCacheClosed = errors.New("cache closed")
+
+// Stops background work, and releases the index if there is one. The cache shouldn't be used
+// after this.
+func (me *Cache) Close() (err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.closed {
+		return
+	}
+	me.closed = true
+	close(me.done)
+	me.events.Close()
+	if me.index != nil {
+		// Files still open may have writes that haven't been indexed yet.
+		for k, i := range me.items {
+			if i.Indexed != nil && indexRecordChanged(*i.Indexed, i, false) {
+				me.index.writeRecord('+', k, i)
+			}
+		}
+		err = me.index.close()
+		me.index = nil
+	}
+	return
+}
+
+func (me *Cache) metaDir() string {
+	return filepath.Join(me.root, metaDirName)
+}
+
+// Returns true if the key refers to the cache's own state.
+func isMetaKey(k key) bool {
+	return k == metaDirName || strings.HasPrefix(string(k), metaDirName+"/")
+}
+
 // An empty return path is an error.
 func sanitizePath(p string) (ret key) {
 	if p == "" {
@@ -127,9 +317,50 @@ func pruneEmptyDirs(root string, leaf string) (err error) {
 }
 
 func (me *Cache) Remove(path string) error {
+	k := sanitizePath(path)
+	if isMetaKey(k) {
+		return ErrBadPath
+	}
 	me.mu.Lock()
 	defer me.mu.Unlock()
-	return me.remove(sanitizePath(path))
+	if i, ok := me.items[k]; ok {
+		me.events.Publish(Event{
+			Kind: EventRemoved,
+			Path: k,
+			Size: i.Size,
+		})
+	}
+	return me.remove(k)
+}
+
+// Removes an item as though the cache chose to. An EventEvicted is published with EvictForced.
+func (me *Cache) Evict(path string) error {
+	k := sanitizePath(path)
+	if k == "" || isMetaKey(k) {
+		return ErrBadPath
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, ok := me.items[k]; !ok {
+		return fs.ErrNotExist
+	}
+	return me.evict(k, EvictForced)
+}
+
+// Brings the items up to date with the filesystem, such as after files were changed behind the
+// cache's back. Items keep their access times and other state.
+func (me *Cache) Rescan() error {
+	<-me.reconciled
+	started := time.Now()
+	me.mu.Lock()
+	for k, i := range me.items {
+		i.Verified = false
+		me.items[k] = i
+	}
+	me.mu.Unlock()
+	err := me.refresh()
+	me.stats.lastInitDurationNanos.Store(int64(time.Since(started)))
+	return err
 }
 
 var (
@@ -148,11 +379,37 @@ func isMissingDir(err error) bool {
 }
 
 func (me *Cache) OpenFile(path string, flag int) (ret *File, err error) {
+	return me.OpenFileOpts(path, flag, OpenOpts{})
+}
+
+func (me *Cache) OpenFileOpts(path string, flag int, opts OpenOpts) (ret *File, err error) {
 	key := sanitizePath(path)
 	if key == "" {
 		err = ErrIsDir
 		return
 	}
+	if isMetaKey(key) {
+		err = ErrBadPath
+		return
+	}
+	me.removeIfExpired(key)
+	if opts.Atomic && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return me.openStaged(key, flag, opts)
+	}
+	writer := me.opts.Dedup && flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if writer {
+		// Counted before unsharing, so the item can't be deduplicated again before it's opened.
+		me.writerOpened(key)
+		defer func() {
+			if err != nil {
+				me.writerClosed(key)
+			}
+		}()
+		err = me.unshare(key, flag&os.O_TRUNC != 0)
+		if err != nil {
+			return
+		}
+	}
 	filePath := me.realpath(key)
 	f, err := os.OpenFile(filePath, flag, filePerm)
 	// Ensure intermediate directories and try again.
@@ -180,13 +437,19 @@ func (me *Cache) OpenFile(path string, flag int) (ret *File, err error) {
 			break
 		}
 	}
+	// Only reads count towards the hit ratio.
+	reading := flag&(os.O_WRONLY|os.O_RDWR) == 0
 	if err != nil {
+		if reading && os.IsNotExist(err) {
+			me.stats.misses.Add(1)
+		}
 		return
 	}
 	ret = &File{
 		path: key,
 		f:    pproffd.WrapOSFile(f),
 		onRead: func(n int) {
+			me.stats.bytesRead.Add(int64(n))
 			me.mu.Lock()
 			defer me.mu.Unlock()
 			me.updateItem(key, func(i *itemState, ok bool) bool {
@@ -194,35 +457,73 @@ func (me *Cache) OpenFile(path string, flag int) (ret *File, err error) {
 				return ok
 			})
 		},
-		afterWrite: func(endOff int64) {
+		afterWrite: func(off int64, n int) {
+			me.stats.bytesWritten.Add(int64(n))
 			me.mu.Lock()
 			defer me.mu.Unlock()
-			me.updateItem(key, func(i *itemState, ok bool) bool {
+			me.writeItem(key, func(i *itemState, ok bool) bool {
+				i.Accessed = time.Now()
+				i.wrote(off, n)
+				return ok
+			})
+		},
+		afterTruncate: func(size int64) {
+			me.mu.Lock()
+			defer me.mu.Unlock()
+			me.writeItem(key, func(i *itemState, ok bool) bool {
 				i.Accessed = time.Now()
-				if endOff > i.Size {
-					i.Size = endOff
-				}
+				i.truncated(size)
 				return ok
 			})
 		},
+		completedFrom: func(off int64) int64 {
+			me.mu.Lock()
+			defer me.mu.Unlock()
+			i, ok := me.items[key]
+			if !ok {
+				// The item was evicted, so reads will find whatever is left.
+				return math.MaxInt64
+			}
+			return i.completedFrom(off)
+		},
+	}
+	ret.afterClose = func(written bool) {
+		if written {
+			me.mu.Lock()
+			me.flushIndexItem(key)
+			me.mu.Unlock()
+		}
+		// The item is deduplicated once the last writer is done with it.
+		if writer && me.writerClosed(key) {
+			me.dedup(key)
+		}
 	}
 	me.mu.Lock()
 	defer me.mu.Unlock()
-	me.updateItem(key, func(i *itemState, ok bool) bool {
+	me.useItem(key, func(i *itemState, ok bool) bool {
+		if reading && ok {
+			me.stats.hits.Add(1)
+		} else if reading {
+			me.stats.misses.Add(1)
+		}
 		if !ok {
-			*i, ok = me.statKey(key)
+			*i, ok = me.statNewKey(key)
+		} else if flag&os.O_TRUNC != 0 {
+			ok = me.restatKey(key, i)
+			i.Completed = nil
+		} else if !i.Verified {
+			ok = me.restatKey(key, i)
 		}
+		me.applyOpenOpts(i, opts)
 		i.Accessed = time.Now()
 		return ok
 	})
 	return
 }
 
-func (me *Cache) rescan() {
-	me.filled = 0
-	me.policy = new(lru)
-	me.items = make(map[key]itemState)
-	err := filepath.Walk(me.root, func(path string, info os.FileInfo, err error) error {
+// Calls the function with the key of every file under the root, skipping the meta directory.
+func (me *Cache) walkFiles(f func(key) error) error {
+	return filepath.Walk(me.root, func(path string, info os.FileInfo, err error) error {
 		if os.IsNotExist(err) {
 			return nil
 		}
@@ -230,6 +531,9 @@ func (me *Cache) rescan() {
 			return err
 		}
 		if info.IsDir() {
+			if path == me.metaDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		path, err = filepath.Rel(me.root, path)
@@ -237,7 +541,12 @@ func (me *Cache) rescan() {
 			log.Print(err)
 			return nil
 		}
-		key := sanitizePath(path)
+		return f(sanitizePath(path))
+	})
+}
+
+func (me *Cache) rescan() {
+	err := me.walkFiles(func(key key) error {
 		me.updateItem(key, func(i *itemState, ok bool) bool {
 			if ok {
 				panic("scanned duplicate items")
@@ -261,24 +570,141 @@ func (me *Cache) statKey(k key) (i itemState, ok bool) {
 		panic(err)
 	}
 	i.FromOSFileInfo(fi)
+	me.applyDefaultTTL(&i, fi.ModTime())
+	if me.objects != nil {
+		i.Hash = me.findObject(fi)
+	}
+	i.Verified = true
 	ok = true
 	return
 }
 
+// Stats a key that's new to the cache, and publishes its creation.
+func (me *Cache) statNewKey(k key) (i itemState, ok bool) {
+	i, ok = me.statKey(k)
+	if ok {
+		me.events.Publish(Event{
+			Kind: EventCreated,
+			Path: k,
+			Size: i.Size,
+		})
+	}
+	return
+}
+
+// Refreshes an item from disk, keeping what's known about its history.
+func (me *Cache) restatKey(k key, i *itemState) (ok bool) {
+	fresh, ok := me.statKey(k)
+	if fresh.Accessed.After(i.Accessed) {
+		i.Accessed = fresh.Accessed
+	}
+	i.Size = fresh.Size
+	i.Hash = fresh.Hash
+	i.Verified = true
+	return
+}
+
+// Updates the item's state. It doesn't count as a use of the item by the policies, but introduces
+// new items to them and keeps them up to date with the item's size.
 func (me *Cache) updateItem(k key, u func(*itemState, bool) bool) {
+	me.updateItemUsed(k, false, false, u)
+}
+
+// Like updateItem, but for a use of the item, such as it being opened.
+func (me *Cache) useItem(k key, u func(*itemState, bool) bool) {
+	me.updateItemUsed(k, true, false, u)
+}
+
+// Like updateItem, but for a write to an open file. Size changes aren't indexed until the file is
+// closed, or indexAccessGranularity has passed.
+func (me *Cache) writeItem(k key, u func(*itemState, bool) bool) {
+	me.updateItemUsed(k, false, true, u)
+}
+
+func (me *Cache) updateItemUsed(k key, used, batched bool, u func(*itemState, bool) bool) {
 	ii, ok := me.items[k]
-	me.filled -= ii.Size
-	if u(&ii, ok) {
-		me.filled += ii.Size
-		me.policy.Used(k, ii.Accessed)
+	if ok {
+		me.unaccount(k, ii)
+	}
+	old := ii
+	exists := u(&ii, ok)
+	me.indexItem(k, &ii, ok, exists, batched)
+	if exists {
+		me.account(k, ii)
+		if used || !ok || ii.Pinned != old.Pinned {
+			me.policyUsed(k, ii)
+		} else if ii.Size != old.Size {
+			me.policySized(k, ii)
+		}
 		me.items[k] = ii
 	} else {
-		me.policy.Forget(k)
+		me.policyForget(k)
 		delete(me.items, k)
 	}
+	if old.Hash != "" {
+		me.maybeRemoveObject(old.Hash)
+	}
+	me.maybeCompactIndex()
 	me.trimToCapacity()
 }
 
+func policyUsed(p Policy, k key, i itemState) {
+	if sp, ok := p.(SizedPolicy); ok {
+		sp.Sized(k, i.Size)
+	}
+	p.Used(k, i.Accessed)
+}
+
+// Updates the policies that apply to the item. Pinned items aren't known to any policy, so they're
+// never chosen for eviction.
+func (me *Cache) policyUsed(k key, i itemState) {
+	if i.Pinned {
+		me.policyForget(k)
+		return
+	}
+	policyUsed(me.policy, k, i)
+	if q, ok := me.quotas[namespaceOf(k)]; ok {
+		policyUsed(q.policy, k, i)
+	}
+}
+
+func policySized(p Policy, k key, i itemState) {
+	if sp, ok := p.(SizedPolicy); ok {
+		sp.Sized(k, i.Size)
+	}
+}
+
+// Tells the policies about a change in the item's size that isn't a use of it.
+func (me *Cache) policySized(k key, i itemState) {
+	if i.Pinned {
+		return
+	}
+	policySized(me.policy, k, i)
+	if q, ok := me.quotas[namespaceOf(k)]; ok {
+		policySized(q.policy, k, i)
+	}
+}
+
+func policyEvicted(p Policy, k key) {
+	if ep, ok := p.(EvictingPolicy); ok {
+		ep.Evicted(k)
+	}
+}
+
+func (me *Cache) policyForget(k key) {
+	me.policy.Forget(k)
+	if q, ok := me.quotas[namespaceOf(k)]; ok {
+		q.policy.Forget(k)
+	}
+}
+
+func (me *Cache) newPolicy() Policy {
+	if me.opts.Policy != nil {
+		return me.opts.Policy()
+	}
+	return new(lru)
+}
+
 func (me *Cache) realpath(path key) string {
 	return filepath.Join(me.root, filepath.FromSlash(string(path)))
 }
@@ -312,13 +738,23 @@ func (me *Cache) remove(path key) error {
 }
 
 func (me *Cache) trimToCapacity() {
+	for ns, q := range me.quotas {
+		for me.nsFilled[ns] > q.limit && q.policy.NumItems() != 0 {
+			k := q.policy.Choose().(key)
+			policyEvicted(q.policy, k)
+			me.evict(k, EvictQuota)
+		}
+	}
 	if me.capacity < 0 {
 		return
 	}
-	for me.filled > me.capacity {
+	// Stop if everything left is pinned.
+	for me.filled > me.capacity && me.policy.NumItems() != 0 {
 		// We can fail to remove things on Windows. We can get stuck in an infinite loop here I
 		// think.
-		if me.remove(me.policy.Choose().(key)) != nil {
+		k := me.policy.Choose().(key)
+		policyEvicted(me.policy, k)
+		if me.evict(k, EvictCapacity) != nil {
 			//return
 		}
 	}
@@ -332,6 +768,9 @@ func (me *Cache) pathInfo(p string) itemState {
 func (me *Cache) Rename(from, to string) (err error) {
 	_from := sanitizePath(from)
 	_to := sanitizePath(to)
+	if _from == "" || _to == "" || isMetaKey(_from) || isMetaKey(_to) {
+		return ErrBadPath
+	}
 	me.mu.Lock()
 	defer me.mu.Unlock()
 	err = os.MkdirAll(filepath.Dir(me.realpath(_to)), dirPerm)
@@ -342,14 +781,24 @@ func (me *Cache) Rename(from, to string) (err error) {
 	if err != nil {
 		return
 	}
-	// We can do a dance here to copy the state from the old item, but lets
-	// just stat the new item for now.
+	// Stat the new item, but carry over the rest of the old item's state.
+	prev, prevOk := me.items[_from]
 	me.updateItem(_from, func(i *itemState, ok bool) bool {
 		return false
 	})
 	me.updateItem(_to, func(i *itemState, ok bool) bool {
-		*i, ok = me.statKey(_to)
-		return ok
+		if !prevOk {
+			*i, ok = me.statKey(_to)
+			return ok
+		}
+		*i = prev
+		return me.restatKey(_to, i)
+	})
+	me.events.Publish(Event{
+		Kind:    EventRenamed,
+		Path:    _to,
+		OldPath: _from,
+		Size:    me.items[_to].Size,
 	})
 	return
 }
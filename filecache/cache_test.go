@@ -172,3 +172,152 @@ func TestFileReadWrite(t *testing.T) {
 	}
 
 }
+
+func TestCachePolicyOpt(t *testing.T) {
+	c, err := NewCacheOpts(t.TempDir(), CacheOpts{Policy: NewGdsfPolicy})
+	assert.NoError(t, err)
+	defer c.Close()
+	writeCacheFile(t, c, "small", "a")
+	writeCacheFile(t, c, "big", "hello world")
+	c.SetCapacity(11)
+	c.TrimToCapacity()
+	writeCacheFile(t, c, "small2", "b")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	assert.Contains(t, c.items, key("small"))
+	assert.Contains(t, c.items, key("small2"))
+	assert.NotContains(t, c.items, key("big"))
+}
+
+func TestSparseItem(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	f, err := c.OpenFile("a", os.O_CREATE|os.O_RDWR)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("world"), 10)
+	require.NoError(t, err)
+	ii, ok := c.Item("a")
+	require.True(t, ok)
+	assert.EqualValues(t, 15, ii.Size)
+	assert.Equal(t, []Range{{0, 5}, {10, 15}}, ii.Completed)
+
+	b := make([]byte, 8)
+	n, err := f.ReadAt(b, 2)
+	assert.ErrorIs(t, err, ErrHole)
+	assert.Equal(t, "llo", string(b[:n]))
+	n, err = f.ReadAt(b[:5], 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(b[:n]))
+	// Reads running off the end of the item find EOF, not a hole.
+	n, err = f.ReadAt(b, 10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "world", string(b[:n]))
+	n, err = f.ReadAt(b, 15)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+
+	// Sequential reads stop short at the hole, and then fail.
+	n, err = f.Read(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b[:n]))
+	_, err = f.Read(b)
+	assert.ErrorIs(t, err, ErrHole)
+
+	// Filling the hole completes the item.
+	_, err = f.WriteAt([]byte("there"), 5)
+	require.NoError(t, err)
+	ii, _ = c.Item("a")
+	assert.Equal(t, []Range{{0, 15}}, ii.Completed)
+	n, err = f.Read(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "therewor", string(b[:n]))
+	all, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<20))
+	require.NoError(t, err)
+	assert.Equal(t, "hellothereworld", string(all))
+}
+
+// A sparse item written out of order can be read to the end.
+func TestSparseItemReadToEnd(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	f, err := c.OpenFile("a", os.O_CREATE|os.O_RDWR)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteAt([]byte("world"), 5)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+	all, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "helloworld", string(all))
+	// Only the tail of an incomplete item is readable to the end.
+	_, err = f.WriteAt([]byte("!"), 20)
+	require.NoError(t, err)
+	b := make([]byte, 10)
+	n, err := f.ReadAt(b, 20)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "!", string(b[:n]))
+	_, err = f.ReadAt(b, 5)
+	assert.ErrorIs(t, err, ErrHole)
+}
+
+func TestEvictAndRescan(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCache(td)
+	require.NoError(t, err)
+	defer c.Close()
+	sub := c.Subscribe()
+	defer sub.Close()
+	writeCacheFile(t, c, "a", "hello")
+	writeCacheFile(t, c, "b", "world!")
+	<-sub.Values
+	<-sub.Values
+	require.NoError(t, c.Evict("a"))
+	assert.Equal(t, Event{Kind: EventEvicted, Path: "a", Size: 5, Reason: EvictForced}, <-sub.Values)
+	assert.ErrorIs(t, c.Evict("a"), os.ErrNotExist)
+	require.NoError(t, c.Pin("b"))
+
+	// Change the tree behind the cache's back.
+	require.NoError(t, os.WriteFile(filepath.Join(td, "b"), []byte("hi"), filePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(td, "c"), []byte("herp"), filePerm))
+	require.NoError(t, c.Rescan())
+	assert.EqualValues(t, 6, c.Info().Filled)
+	ii, ok := c.Item("b")
+	require.True(t, ok)
+	assert.EqualValues(t, 2, ii.Size)
+	assert.True(t, ii.Pinned)
+	require.NoError(t, os.Remove(filepath.Join(td, "c")))
+	require.NoError(t, c.Rescan())
+	_, ok = c.Item("c")
+	assert.False(t, ok)
+}
+
+func TestTruncate(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	defer c.Close()
+	f, err := c.OpenFile("a", os.O_CREATE|os.O_RDWR)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+	// Growing the item leaves a hole.
+	require.NoError(t, f.Truncate(10))
+	ii, ok := c.Item("a")
+	require.True(t, ok)
+	assert.EqualValues(t, 10, ii.Size)
+	assert.Equal(t, []Range{{0, 5}}, ii.Completed)
+	assert.False(t, ii.IsComplete())
+	b := make([]byte, 8)
+	n, err := f.ReadAt(b, 2)
+	assert.ErrorIs(t, err, ErrHole)
+	assert.Equal(t, "llo", string(b[:n]))
+	// Shrinking it back to what was written completes it.
+	require.NoError(t, f.Truncate(3))
+	ii, _ = c.Item("a")
+	assert.EqualValues(t, 3, ii.Size)
+	assert.True(t, ii.IsComplete())
+}
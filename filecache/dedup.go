@@ -0,0 +1,334 @@
+package filecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/anacrolix/log"
+)
+
+// In dedup mode, completed items are hashed when a file that wrote to them is closed, and stored
+// once in the objects directory under the meta directory. Item paths become hard links to the
+// object, and capacity is only accounted for once per object. An object is removed when its last
+// item is. Items aren't deduplicated while any writable file is open on them, and objects are
+// always fresh copies, so nothing can write to an object after it's hashed.
+
+const (
+	objectsDirName = "objects"
+	tmpDirName     = "tmp"
+)
+
+type object struct {
+	size int64
+	refs int
+	// Used to match scanned items to the object.
+	fi os.FileInfo
+}
+
+func (me *Cache) objectsDir() string {
+	return filepath.Join(me.metaDir(), objectsDirName)
+}
+
+func (me *Cache) objectPath(hash string) string {
+	return filepath.Join(me.objectsDir(), hash[:2], hash[2:])
+}
+
+// Returns a new file in the meta directory that's later renamed into place.
+func (me *Cache) tmpFile() (*os.File, error) {
+	dir := me.tmpDir()
+	err := os.MkdirAll(dir, dirPerm)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "")
+	if err != nil {
+		return nil, err
+	}
+	err = f.Chmod(filePerm)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// Adds an item's bytes to the filled count. Namespaces are charged for the item's size regardless
+// of deduplication.
+func (me *Cache) account(k key, i itemState) {
+	me.nsFilled[namespaceOf(k)] += i.Size
+	if i.Pinned {
+		me.pinned++
+		me.pinnedFilled += i.Size
+	}
+	if i.Hash == "" {
+		me.filled += i.Size
+		return
+	}
+	obj := me.objects[i.Hash]
+	if obj.refs == 0 {
+		me.filled += obj.size
+	}
+	obj.refs++
+}
+
+func (me *Cache) unaccount(k key, i itemState) {
+	ns := namespaceOf(k)
+	me.nsFilled[ns] -= i.Size
+	if me.nsFilled[ns] == 0 {
+		delete(me.nsFilled, ns)
+	}
+	if i.Pinned {
+		me.pinned--
+		me.pinnedFilled -= i.Size
+	}
+	if i.Hash == "" {
+		me.filled -= i.Size
+		return
+	}
+	obj := me.objects[i.Hash]
+	obj.refs--
+	if obj.refs == 0 {
+		me.filled -= obj.size
+	}
+}
+
+// Deletes the object if nothing refers to it anymore.
+func (me *Cache) maybeRemoveObject(hash string) {
+	obj, ok := me.objects[hash]
+	if !ok || obj.refs != 0 {
+		return
+	}
+	delete(me.objects, hash)
+	p := me.objectPath(hash)
+	err := os.Remove(p)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("error removing object %q: %v", hash, err)
+	}
+	pruneEmptyDirs(me.objectsDir(), filepath.Dir(p))
+}
+
+// Loads the objects directory, so that items found during a scan can be matched to them.
+func (me *Cache) scanObjects() {
+	me.objects = make(map[string]*object)
+	me.objectsBySize = make(map[int64][]string)
+	filepath.Walk(me.objectsDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(me.objectsDir(), path)
+		if err != nil {
+			return nil
+		}
+		hash := filepath.Dir(rel) + filepath.Base(rel)
+		if len(hash) != 2*sha256.Size {
+			return nil
+		}
+		me.addObject(hash, info)
+		return nil
+	})
+}
+
+func (me *Cache) addObject(hash string, fi os.FileInfo) *object {
+	obj := &object{size: fi.Size(), fi: fi}
+	me.objects[hash] = obj
+	me.objectsBySize[obj.size] = append(me.objectsBySize[obj.size], hash)
+	return obj
+}
+
+// Returns the hash of the object the file is a link to, if any.
+func (me *Cache) findObject(fi os.FileInfo) string {
+	for _, hash := range me.objectsBySize[fi.Size()] {
+		obj := me.objects[hash]
+		if obj != nil && obj.fi != nil && os.SameFile(obj.fi, fi) {
+			return hash
+		}
+	}
+	return ""
+}
+
+// Removes objects that no item refers to, such as after items were deleted behind the cache's back.
+func (me *Cache) removeOrphanObjects() {
+	for hash := range me.objects {
+		me.maybeRemoveObject(hash)
+	}
+}
+
+func (me *Cache) writerOpened(k key) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.writers == nil {
+		me.writers = make(map[key]int)
+	}
+	me.writers[k]++
+}
+
+// Returns true if it was the last writer.
+func (me *Cache) writerClosed(k key) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.writers[k]--
+	if me.writers[k] > 0 {
+		return false
+	}
+	delete(me.writers, k)
+	return true
+}
+
+// Copies the file to a new temporary file, returning the hash of the contents.
+func copyAndHash(path string, tmp *os.File) (hash string, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tmp, h), f)
+	hash = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// Returns whether the item can be deduplicated. Called with the lock held.
+func (me *Cache) dedupable(k key) bool {
+	i, ok := me.items[k]
+	return ok && i.Hash == "" && i.Completed == nil && me.writers[k] == 0
+}
+
+// Replaces the item's file with a link to the object with the same contents, creating the object
+// from a copy of the item if it doesn't exist.
+func (me *Cache) dedup(k key) {
+	me.mu.Lock()
+	ok := me.dedupable(k)
+	me.mu.Unlock()
+	if !ok {
+		return
+	}
+	before, err := os.Stat(me.realpath(k))
+	if err != nil {
+		return
+	}
+	tmp, err := me.tmpFile()
+	if err != nil {
+		log.Printf("error deduplicating %q: %v", k, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	hash, size, err := copyAndHash(me.realpath(k), tmp)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("error hashing %q: %v", k, err)
+		return
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	after, err := os.Stat(me.realpath(k))
+	if err != nil || !me.dedupable(k) || me.items[k].Size != size || !sameVersion(before, after) {
+		// It changed while we were hashing it.
+		return
+	}
+	err = me.linkObject(k, hash, tmp.Name())
+	if err != nil {
+		log.Printf("error deduplicating %q: %v", k, err)
+		// Don't leave behind an object that was created for the item.
+		me.maybeRemoveObject(hash)
+		return
+	}
+	me.updateItem(k, func(i *itemState, ok bool) bool {
+		i.Hash = hash
+		return ok
+	})
+}
+
+func sameVersion(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// Replaces the item with a link to the object. If the object doesn't exist, it's created from the
+// copy of the item at tmpPath.
+func (me *Cache) linkObject(k key, hash, tmpPath string) (err error) {
+	objPath := me.objectPath(hash)
+	if _, ok := me.objects[hash]; !ok {
+		err = os.MkdirAll(filepath.Dir(objPath), dirPerm)
+		if err != nil {
+			return
+		}
+		err = os.Rename(tmpPath, objPath)
+		if err != nil {
+			return
+		}
+		var fi os.FileInfo
+		fi, err = os.Stat(objPath)
+		if err != nil {
+			return
+		}
+		me.addObject(hash, fi)
+	}
+	// Link the object in under a temporary name, and replace the item with it.
+	tmp, err := me.tmpFile()
+	if err != nil {
+		return
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	err = os.Link(objPath, tmp.Name())
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), me.realpath(k))
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return
+}
+
+// Gives the item its own copy of its object, so it can be modified without affecting other items.
+// If truncate is set, the contents aren't copied. The copy is made without the lock held, as it can
+// be large.
+func (me *Cache) unshare(k key, truncate bool) (err error) {
+	me.mu.Lock()
+	i, ok := me.items[k]
+	me.mu.Unlock()
+	if !ok || i.Hash == "" {
+		return
+	}
+	tmp, err := me.tmpFile()
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if !truncate {
+		var src *os.File
+		src, err = os.Open(me.objectPath(i.Hash))
+		if err == nil {
+			_, err = io.Copy(tmp, src)
+			src.Close()
+		}
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if now, ok := me.items[k]; !ok || now.Hash != i.Hash {
+		// It was removed, replaced or unshared by another writer while we copied it.
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), me.realpath(k))
+	if err != nil {
+		return
+	}
+	me.updateItem(k, func(i *itemState, ok bool) bool {
+		return me.restatKey(k, i)
+	})
+	return
+}
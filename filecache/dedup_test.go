@@ -0,0 +1,125 @@
+package filecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func numObjects(t *testing.T, c *Cache) (n int) {
+	filepath.Walk(c.objectsDir(), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+func TestDedup(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCacheOpts(td, CacheOpts{Dedup: true})
+	require.NoError(t, err)
+	writeCacheFile(t, c, "a", "hello")
+	writeCacheFile(t, c, "dir/b", "hello")
+	writeCacheFile(t, c, "c", "world!")
+	assert.EqualValues(t, CacheInfo{
+		Capacity: -1,
+		Filled:   11,
+		NumItems: 3,
+	}, c.Info())
+	assert.Equal(t, 2, numObjects(t, c))
+	a, _ := c.Item("a")
+	b, _ := c.Item("dir/b")
+	assert.NotEmpty(t, a.Hash)
+	assert.Equal(t, a.Hash, b.Hash)
+
+	// Writing to an item doesn't affect others with the same contents.
+	f, err := c.OpenFile("a", os.O_WRONLY)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("J"), 0)
+	require.NoError(t, err)
+	assert.EqualValues(t, 16, c.Info().Filled)
+	require.NoError(t, f.Close())
+	contents, err := os.ReadFile(filepath.Join(td, "dir", "b"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(contents))
+	assert.Equal(t, 3, numObjects(t, c))
+
+	// Objects are only removed with their last reference.
+	require.NoError(t, c.Remove("a"))
+	writeCacheFile(t, c, "d", "hello")
+	require.NoError(t, c.Remove("dir/b"))
+	assert.Equal(t, 2, numObjects(t, c))
+	require.NoError(t, c.Remove("d"))
+	assert.Equal(t, 1, numObjects(t, c))
+	assert.EqualValues(t, 6, c.Info().Filled)
+
+	// A rescan finds the links to objects.
+	writeCacheFile(t, c, "e", "world!")
+	c, err = NewCacheOpts(td, CacheOpts{Dedup: true})
+	require.NoError(t, err)
+	assert.EqualValues(t, CacheInfo{
+		Capacity: -1,
+		Filled:   6,
+		NumItems: 2,
+	}, c.Info())
+	e, _ := c.Item("e")
+	assert.NotEmpty(t, e.Hash)
+}
+
+// An item isn't deduplicated while it's open for writing, and its object is a new file rather than
+// the one writers had open.
+func TestDedupWaitsForWriters(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCacheOpts(td, CacheOpts{Dedup: true})
+	require.NoError(t, err)
+	w, err := c.OpenFile("a", os.O_CREATE|os.O_WRONLY)
+	require.NoError(t, err)
+	writeCacheFile(t, c, "a", "hello")
+	a, _ := c.Item("a")
+	assert.Empty(t, a.Hash)
+	assert.Equal(t, 0, numObjects(t, c))
+	written, err := os.Stat(filepath.Join(td, "a"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	a, _ = c.Item("a")
+	require.NotEmpty(t, a.Hash)
+	obj, err := os.Stat(c.objectPath(a.Hash))
+	require.NoError(t, err)
+	assert.False(t, os.SameFile(written, obj))
+	item, err := os.Stat(filepath.Join(td, "a"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(item, obj))
+}
+
+// Giving an item its own copy to write to keeps its pin and expiry.
+func TestDedupUnshareKeepsState(t *testing.T) {
+	c, err := NewCacheOpts(t.TempDir(), CacheOpts{Dedup: true})
+	require.NoError(t, err)
+	defer c.Close()
+	writeCacheFile(t, c, "a", "hello")
+	writeCacheFile(t, c, "b", "hello")
+	f, err := c.OpenFileOpts("a", os.O_WRONLY, OpenOpts{TTL: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, c.Pin("a"))
+	before, ok := c.Item("a")
+	require.True(t, ok)
+	require.NotEmpty(t, before.Hash)
+	require.True(t, before.Pinned)
+	require.False(t, before.Expires.IsZero())
+	f, err = c.OpenFile("a", os.O_WRONLY)
+	require.NoError(t, err)
+	defer f.Close()
+	after, ok := c.Item("a")
+	require.True(t, ok)
+	assert.Empty(t, after.Hash)
+	assert.True(t, after.Pinned)
+	assert.Equal(t, before.Expires, after.Expires)
+	assert.False(t, after.Accessed.Before(before.Accessed))
+}
@@ -0,0 +1,91 @@
+package filecache
+
+import (
+	"github.com/anacrolix/missinggo/v2/pubsub"
+)
+
+type EventKind int
+
+const (
+	// An item was added to the cache through OpenFile.
+	EventCreated EventKind = iota
+	// An item was removed through Remove.
+	EventRemoved
+	// An item was moved through Rename. The Event's OldPath is where it was.
+	EventRenamed
+	// An item was removed by the cache. The Event's Reason says why.
+	EventEvicted
+)
+
+func (me EventKind) String() string {
+	switch me {
+	case EventCreated:
+		return "created"
+	case EventRemoved:
+		return "removed"
+	case EventRenamed:
+		return "renamed"
+	case EventEvicted:
+		return "evicted"
+	default:
+		return "unknown"
+	}
+}
+
+type EvictReason int
+
+const (
+	EvictNone EvictReason = iota
+	// The cache was over capacity.
+	EvictCapacity
+	// The item's namespace was over its quota.
+	EvictQuota
+	// The item expired.
+	EvictExpired
+	// The item was removed through Evict.
+	EvictForced
+)
+
+func (me EvictReason) String() string {
+	switch me {
+	case EvictNone:
+		return "none"
+	case EvictCapacity:
+		return "capacity"
+	case EvictQuota:
+		return "quota"
+	case EvictExpired:
+		return "expired"
+	case EvictForced:
+		return "forced"
+	default:
+		return "unknown"
+	}
+}
+
+type Event struct {
+	Kind    EventKind
+	Path    key
+	OldPath key
+	Size    int64
+	Reason  EvictReason
+}
+
+// Returns a subscription to events for changes to items in the cache. Events are published without
+// waiting for subscribers, which are responsible for keeping up. The subscription should be closed
+// when it's no longer needed.
+func (me *Cache) Subscribe() *pubsub.Subscription[Event] {
+	return me.events.Subscribe()
+}
+
+// Removes an item on the cache's own initiative.
+func (me *Cache) evict(k key, reason EvictReason) error {
+	me.stats.evictions[reason].Add(1)
+	me.events.Publish(Event{
+		Kind:   EventEvicted,
+		Path:   k,
+		Size:   me.items[k].Size,
+		Reason: reason,
+	})
+	return me.remove(k)
+}
@@ -0,0 +1,53 @@
+package filecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bradfitz/iter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextEvent(t *testing.T, values <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-values:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+		panic("unreachable")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	defer c.Close()
+	sub := c.Subscribe()
+	defer sub.Close()
+	writeCacheFile(t, c, "a", "hello")
+	require.NoError(t, c.Rename("a", "b"))
+	writeCacheFile(t, c, "c", "world!")
+	c.SetCapacity(6)
+	c.TrimToCapacity()
+	require.NoError(t, c.Remove("c"))
+	assert.Equal(t, Event{Kind: EventCreated, Path: "a"}, nextEvent(t, sub.Values))
+	assert.Equal(t, Event{Kind: EventRenamed, Path: "b", OldPath: "a", Size: 5}, nextEvent(t, sub.Values))
+	assert.Equal(t, Event{Kind: EventCreated, Path: "c"}, nextEvent(t, sub.Values))
+	assert.Equal(t, Event{Kind: EventEvicted, Path: "b", Size: 5, Reason: EvictCapacity}, nextEvent(t, sub.Values))
+	assert.Equal(t, Event{Kind: EventRemoved, Path: "c", Size: 6}, nextEvent(t, sub.Values))
+}
+
+func TestSlowSubscriberDoesntBlock(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	defer c.Close()
+	sub := c.Subscribe()
+	defer sub.Close()
+	for range iter.N(100) {
+		writeCacheFile(t, c, "a", "hello")
+		require.NoError(t, c.Remove("a"))
+	}
+	assert.Equal(t, EventCreated, nextEvent(t, sub.Values).Kind)
+}
@@ -0,0 +1,131 @@
+package filecache
+
+import (
+	"time"
+)
+
+// Items can expire a fixed time after they enter the cache (TTL), or after going unaccessed for a
+// period (max idle). Either can be set per item when it's opened, with defaults for the whole
+// cache. Expired items are removed by a reaper that runs periodically, and can't be opened in the
+// meantime. Pinned items don't expire. The reaper is only started once something can expire.
+
+const defaultReapInterval = time.Minute
+
+type OpenOpts struct {
+	// If positive, the item expires this long from now.
+	TTL time.Duration
+	// If positive, the item expires after going this long without being accessed. Overrides the
+	// cache default.
+	MaxIdle time.Duration
+	// Writes go to a staging file that replaces the item when committed. See File.Commit.
+	Atomic bool
+}
+
+// Applies the cache's default TTL to an item entering the cache.
+func (me *Cache) applyDefaultTTL(i *itemState, since time.Time) {
+	if me.opts.TTL > 0 && i.Expires.IsZero() {
+		i.Expires = since.Add(me.opts.TTL)
+	}
+}
+
+func (me *Cache) applyOpenOpts(i *itemState, opts OpenOpts) {
+	if opts.TTL > 0 {
+		i.Expires = time.Now().Add(opts.TTL)
+		me.startReaper()
+	}
+	if opts.MaxIdle > 0 {
+		i.MaxIdle = opts.MaxIdle
+		me.startReaper()
+	}
+}
+
+// Starts the reaper if the cache defaults or any item can expire. Called with the lock held.
+func (me *Cache) startReaperIfNeeded() {
+	if me.opts.TTL > 0 || me.opts.MaxIdle > 0 {
+		me.startReaper()
+		return
+	}
+	for _, i := range me.items {
+		if !i.Expires.IsZero() || i.MaxIdle > 0 {
+			me.startReaper()
+			return
+		}
+	}
+}
+
+// Called with the lock held.
+func (me *Cache) startReaper() {
+	if me.reaping || me.closed {
+		return
+	}
+	me.reaping = true
+	go me.reaper()
+}
+
+// Returns when the item expires, or the zero Time if it doesn't.
+func (me *Cache) expiry(i itemState) (ret time.Time) {
+	ret = i.Expires
+	maxIdle := i.MaxIdle
+	if maxIdle <= 0 {
+		maxIdle = me.opts.MaxIdle
+	}
+	if maxIdle > 0 {
+		idle := i.Accessed.Add(maxIdle)
+		if ret.IsZero() || idle.Before(ret) {
+			ret = idle
+		}
+	}
+	return
+}
+
+func (me *Cache) expired(i itemState, now time.Time) bool {
+	if i.Pinned {
+		return false
+	}
+	e := me.expiry(i)
+	return !e.IsZero() && !now.Before(e)
+}
+
+// Removes all expired items.
+func (me *Cache) reap() {
+	now := time.Now()
+	for k, i := range me.items {
+		if me.expired(i, now) {
+			me.evict(k, EvictExpired)
+		}
+	}
+}
+
+func (me *Cache) reaper() {
+	interval := me.opts.ReapInterval
+	if interval <= 0 {
+		interval = defaultReapInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-me.done:
+			return
+		}
+		me.mu.Lock()
+		me.reap()
+		me.mu.Unlock()
+	}
+}
+
+// Removes expired items now, rather than waiting for the reaper.
+func (me *Cache) Reap() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.reap()
+}
+
+func (me *Cache) removeIfExpired(k key) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if i, ok := me.items[k]; ok && me.expired(i, time.Now()) {
+		me.evict(k, EvictExpired)
+	}
+}
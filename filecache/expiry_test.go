@@ -0,0 +1,98 @@
+package filecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestItemTTL(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCacheOpts(td, CacheOpts{ReapInterval: time.Hour})
+	require.NoError(t, err)
+	defer c.Close()
+	f, err := c.OpenFileOpts("dir/a", os.O_CREATE|os.O_WRONLY, OpenOpts{TTL: -time.Second})
+	require.NoError(t, err)
+	f.Close()
+	ii, ok := c.Item("dir/a")
+	require.True(t, ok)
+	assert.True(t, ii.Expires.IsZero())
+
+	f, err = c.OpenFileOpts("dir/a", os.O_WRONLY, OpenOpts{TTL: time.Nanosecond})
+	require.NoError(t, err)
+	f.Close()
+	ii, _ = c.Item("dir/a")
+	assert.False(t, ii.Expires.IsZero())
+	time.Sleep(time.Millisecond)
+	_, err = c.OpenFile("dir/a", os.O_RDONLY)
+	assert.True(t, os.IsNotExist(err), err)
+	assert.NoDirExists(t, filepath.Join(td, "dir"))
+}
+
+func TestDefaultMaxIdleReaper(t *testing.T) {
+	c, err := NewCacheOpts(t.TempDir(), CacheOpts{
+		MaxIdle:      50 * time.Millisecond,
+		ReapInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+	writeCacheFile(t, c, "a", "hello")
+	writeCacheFile(t, c, "b", "world")
+	require.NoError(t, c.Pin("b"))
+	// Per-item max idle overrides the default.
+	f, err := c.OpenFileOpts("c", os.O_CREATE, OpenOpts{MaxIdle: time.Hour})
+	require.NoError(t, err)
+	f.Close()
+	ii, _ := c.Item("a")
+	assert.WithinDuration(t, ii.Accessed.Add(50*time.Millisecond), ii.Expires, 0)
+	assert.Eventually(t, func() bool {
+		_, ok := c.Item("a")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	_, ok := c.Item("b")
+	assert.True(t, ok)
+	_, ok = c.Item("c")
+	assert.True(t, ok)
+}
+
+func TestIndexKeepsExpiry(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	f, err := c.OpenFileOpts("a", os.O_CREATE, OpenOpts{TTL: time.Hour, MaxIdle: 2 * time.Hour})
+	require.NoError(t, err)
+	f.Close()
+	before, _ := c.Item("a")
+	require.NoError(t, c.Close())
+	c, err = NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	defer c.Close()
+	<-c.reconciled
+	after, _ := c.Item("a")
+	assert.True(t, before.Expires.Equal(after.Expires))
+}
+
+func TestReaperStartsOnFirstExpiry(t *testing.T) {
+	c, err := NewCacheOpts(t.TempDir(), CacheOpts{ReapInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer c.Close()
+	writeCacheFile(t, c, "a", "hello")
+	reaping := func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.reaping
+	}
+	assert.False(t, reaping())
+	f, err := c.OpenFileOpts("a", os.O_RDONLY, OpenOpts{TTL: time.Millisecond})
+	require.NoError(t, err)
+	f.Close()
+	assert.True(t, reaping())
+	assert.Eventually(t, func() bool {
+		_, ok := c.Item("a")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
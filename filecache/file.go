@@ -11,10 +11,19 @@ import (
 type File struct {
 	path       key
 	f          pproffd.OSFile
-	afterWrite func(endOff int64)
-	onRead     func(n int)
-	mu         sync.Mutex
-	offset     int64
+	afterWrite func(off int64, n int)
+	// Called after the file is resized.
+	afterTruncate func(size int64)
+	onRead        func(n int)
+	// Returns the end of the data written to the item starting at off.
+	completedFrom func(off int64) int64
+	// Called after the file is closed, if it's not nil.
+	afterClose func(written bool)
+	// Set for files opened with OpenOpts.Atomic.
+	commit  func() error
+	written bool
+	mu      sync.Mutex
+	offset  int64
 }
 
 func (me *File) Seek(offset int64, whence int) (ret int64, err error) {
@@ -29,23 +38,62 @@ func (me *File) Seek(offset int64, whence int) (ret int64, err error) {
 var (
 	ErrFileTooLarge    = errors.New("file too large for cache")
 	ErrFileDisappeared = errors.New("file disappeared")
+	// Returned by reads that reach a part of the item that hasn't been written.
+	ErrHole = errors.New("read of unwritten data")
 )
 
 func (me *File) Write(b []byte) (n int, err error) {
+	off := me.offset
 	n, err = me.f.Write(b)
 	me.offset += int64(n)
-	me.afterWrite(me.offset)
+	me.written = true
+	me.afterWrite(off, n)
 	return
 }
 
 func (me *File) WriteAt(b []byte, off int64) (n int, err error) {
 	n, err = me.f.WriteAt(b, off)
-	me.afterWrite(off + int64(n))
+	me.written = true
+	me.afterWrite(off, n)
 	return
 }
 
+// Changes the size of the item. Growing it leaves a hole, which reads return ErrHole for until it's
+// written.
+func (me *File) Truncate(size int64) (err error) {
+	err = me.f.Truncate(size)
+	if err != nil {
+		return
+	}
+	me.written = true
+	me.afterTruncate(size)
+	return
+}
+
+// Truncates b to the written data at off. Returns ErrHole if that's shorter than b.
+func (me *File) limitToCompleted(b []byte, off int64) ([]byte, error) {
+	end := me.completedFrom(off)
+	if end-off >= int64(len(b)) {
+		return b, nil
+	}
+	return b[:end-off], ErrHole
+}
+
 func (me *File) Close() error {
-	return me.f.Close()
+	err := me.f.Close()
+	if err == nil && me.afterClose != nil {
+		me.afterClose(me.written)
+	}
+	return err
+}
+
+// Makes the data written visible at the item's path, and closes the file. For files not opened with
+// OpenOpts.Atomic, this is the same as Close.
+func (me *File) Commit() error {
+	if me.commit == nil {
+		return me.Close()
+	}
+	return me.commit()
 }
 
 func (me *File) Stat() (os.FileInfo, error) {
@@ -53,13 +101,22 @@ func (me *File) Stat() (os.FileInfo, error) {
 }
 
 func (me *File) Read(b []byte) (n int, err error) {
+	b, holeErr := me.limitToCompleted(b, me.offset)
+	if len(b) == 0 && holeErr != nil {
+		return 0, holeErr
+	}
 	n, err = me.f.Read(b)
+	me.offset += int64(n)
 	me.onRead(n)
 	return
 }
 
 func (me *File) ReadAt(b []byte, off int64) (n int, err error) {
+	b, holeErr := me.limitToCompleted(b, off)
 	n, err = me.f.ReadAt(b, off)
 	me.onRead(n)
+	if err == nil {
+		err = holeErr
+	}
 	return
 }
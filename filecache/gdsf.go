@@ -0,0 +1,128 @@
+package filecache
+
+import (
+	"time"
+
+	"github.com/anacrolix/missinggo/orderedmap"
+)
+
+// GreedyDual-Size-Frequency. Items are prioritized by their use count divided by their size, plus
+// an inflation value that rises to the priority of each evicted item, so that items that were
+// popular long ago eventually age out. This favours keeping many small hot items over a few large
+// ones.
+type gdsf struct {
+	o         orderedmap.OrderedMap
+	oKeys     map[policyItemKey]gdsfKey
+	sizes     map[policyItemKey]int64
+	inflation float64
+}
+
+type gdsfKey struct {
+	item     policyItemKey
+	priority float64
+	uses     int64
+}
+
+func (me gdsfKey) Before(other gdsfKey) bool {
+	if me.priority != other.priority {
+		return me.priority < other.priority
+	}
+	return me.item.Before(other.item)
+}
+
+var (
+	_ SizedPolicy    = (*gdsf)(nil)
+	_ EvictingPolicy = (*gdsf)(nil)
+)
+
+func NewGdsfPolicy() Policy {
+	return new(gdsf)
+}
+
+func (me *gdsf) first() (ret gdsfKey, ok bool) {
+	if me.o == nil {
+		return
+	}
+	me.o.Iter(func(i interface{}) bool {
+		ret = i.(gdsfKey)
+		ok = true
+		return false
+	})
+	return
+}
+
+func (me *gdsf) Choose() policyItemKey {
+	gk, ok := me.first()
+	if !ok {
+		panic("cache empty")
+	}
+	return gk.item
+}
+
+func (me *gdsf) priority(k policyItemKey, uses int64) float64 {
+	return me.inflation + me.frequency(k, uses)
+}
+
+// The uses of the item per byte.
+func (me *gdsf) frequency(k policyItemKey, uses int64) float64 {
+	size := me.sizes[k]
+	if size < 1 {
+		size = 1
+	}
+	return float64(uses) / float64(size)
+}
+
+func (me *gdsf) Used(k policyItemKey, at time.Time) {
+	if me.o == nil {
+		me.o = orderedmap.NewGoogleBTree(func(l, r interface{}) bool {
+			return l.(gdsfKey).Before(r.(gdsfKey))
+		})
+	}
+	if me.oKeys == nil {
+		me.oKeys = make(map[policyItemKey]gdsfKey)
+	}
+	gk, ok := me.oKeys[k]
+	if ok {
+		me.o.Unset(gk)
+	}
+	gk = gdsfKey{k, me.priority(k, gk.uses+1), gk.uses + 1}
+	me.o.Set(gk, gk)
+	me.oKeys[k] = gk
+}
+
+func (me *gdsf) Sized(k policyItemKey, size int64) {
+	if me.sizes == nil {
+		me.sizes = make(map[policyItemKey]int64)
+	}
+	gk, ok := me.oKeys[k]
+	if !ok {
+		me.sizes[k] = size
+		return
+	}
+	// Reprioritize for the new size, keeping the inflation from when it was last used.
+	me.o.Unset(gk)
+	gk.priority -= me.frequency(k, gk.uses)
+	me.sizes[k] = size
+	gk.priority += me.frequency(k, gk.uses)
+	me.o.Set(gk, gk)
+	me.oKeys[k] = gk
+}
+
+func (me *gdsf) Evicted(k policyItemKey) {
+	if gk, ok := me.oKeys[k]; ok && gk.priority > me.inflation {
+		me.inflation = gk.priority
+	}
+}
+
+func (me *gdsf) Forget(k policyItemKey) {
+	gk, ok := me.oKeys[k]
+	if ok {
+		me.o.Unset(gk)
+	}
+	delete(me.oKeys, k)
+	delete(me.sizes, k)
+}
+
+func (me *gdsf) NumItems() int {
+	return len(me.oKeys)
+}
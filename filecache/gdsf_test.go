@@ -0,0 +1,60 @@
+package filecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGDSF(t *testing.T) {
+	testPolicy(t, NewGdsfPolicy())
+}
+
+func TestGdsfEvictsLargeItems(t *testing.T) {
+	p := NewGdsfPolicy().(SizedPolicy)
+	now := time.Now()
+	p.Sized(key("big"), 1000)
+	p.Used(key("big"), now)
+	p.Sized(key("small"), 10)
+	p.Used(key("small"), now.Add(-time.Hour))
+	assert.Equal(t, key("big"), p.Choose())
+	p.(EvictingPolicy).Evicted(key("big"))
+	p.Forget(key("big"))
+	// New items start from the priority of the evicted item, so an item of the same size and use
+	// count as one that has been around longer is kept in preference.
+	p.Sized(key("new"), 10)
+	p.Used(key("new"), now.Add(-2*time.Hour))
+	assert.Equal(t, key("small"), p.Choose())
+}
+
+// Only evictions raise the priority new items start from.
+func TestGdsfForgetDoesntInflate(t *testing.T) {
+	p := NewGdsfPolicy().(SizedPolicy)
+	now := time.Now()
+	p.Sized(key("a"), 2)
+	for range 3 {
+		p.Used(key("a"), now)
+	}
+	p.Sized(key("b"), 1)
+	p.Used(key("b"), now)
+	assert.Equal(t, key("b"), p.Choose())
+	// Removed, rather than evicted.
+	p.Forget(key("b"))
+	p.Sized(key("c"), 1)
+	p.Used(key("c"), now)
+	assert.Equal(t, key("c"), p.Choose())
+}
+
+// An item's priority follows its size as it changes between uses.
+func TestGdsfResized(t *testing.T) {
+	p := NewGdsfPolicy().(SizedPolicy)
+	now := time.Now()
+	p.Sized(key("a"), 1)
+	p.Used(key("a"), now)
+	p.Sized(key("b"), 10)
+	p.Used(key("b"), now)
+	assert.Equal(t, key("b"), p.Choose())
+	p.Sized(key("a"), 100)
+	assert.Equal(t, key("a"), p.Choose())
+}
@@ -0,0 +1,379 @@
+package filecache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// The index is an append-only log of item states kept in the cache's meta directory. It's replayed
+// on start so the cache is usable without walking the whole tree, and then reconciled against the
+// filesystem in the background.
+
+const (
+	indexFileName = "index"
+	indexHeader   = "filecache-index 5"
+	// Access time changes smaller than this aren't written to the index. Eviction order is only
+	// approximate across restarts anyway.
+	indexAccessGranularity = time.Minute
+)
+
+var errIndexCorrupt = errors.New("index corrupt")
+
+type index struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	// Number of records in the log. Used to decide when to compact.
+	records int
+}
+
+func (me *index) writeRecord(op byte, k key, i itemState) (err error) {
+	me.records++
+	_, err = fmt.Fprintf(
+		me.w, "%c %d %d %s %s %s %s %d %s\n",
+		op, i.Size, i.Accessed.UnixNano(), formatIndexRanges(i.Completed), formatIndexHash(i.Hash),
+		formatIndexFlags(i), formatIndexTime(i.Expires), i.MaxIdle, strconv.Quote(string(k)))
+	return
+}
+
+// The completed ranges are written as "*" if the item is complete, and "-" if nothing is written.
+func formatIndexRanges(rs ranges) string {
+	switch {
+	case rs == nil:
+		return "*"
+	case len(rs) == 0:
+		return "-"
+	default:
+		return rs.String()
+	}
+}
+
+func formatIndexHash(hash string) string {
+	if hash == "" {
+		return "-"
+	}
+	return hash
+}
+
+// Flags are single characters, with "-" for none. "p" is for pinned.
+func formatIndexFlags(i itemState) string {
+	if i.Pinned {
+		return "p"
+	}
+	return "-"
+}
+
+func formatIndexTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func parseIndexTime(s string) (t time.Time, err error) {
+	if s == "-" {
+		return
+	}
+	ns, err := strconv.ParseInt(s, 10, 64)
+	t = time.Unix(0, ns)
+	return
+}
+
+func parseIndexRanges(s string) (ranges, error) {
+	switch s {
+	case "*":
+		return nil, nil
+	case "-":
+		return ranges{}, nil
+	default:
+		return parseRanges(s)
+	}
+}
+
+func (me *index) appendRecord(op byte, k key, i itemState) error {
+	err := me.writeRecord(op, k, i)
+	if err != nil {
+		return err
+	}
+	return me.w.Flush()
+}
+
+func (me *index) close() error {
+	flushErr := me.w.Flush()
+	err := me.f.Close()
+	if err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func parseIndexRecord(line string) (op byte, k key, i itemState, err error) {
+	fields := strings.SplitN(line, " ", 9)
+	if len(fields) != 9 || len(fields[0]) != 1 {
+		err = errIndexCorrupt
+		return
+	}
+	op = fields[0][0]
+	if op != '+' && op != '-' {
+		err = errIndexCorrupt
+		return
+	}
+	i.Size, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return
+	}
+	accessed, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return
+	}
+	i.Accessed = time.Unix(0, accessed)
+	i.Completed, err = parseIndexRanges(fields[3])
+	if err != nil {
+		return
+	}
+	if fields[4] != "-" {
+		i.Hash = fields[4]
+	}
+	i.Pinned = strings.Contains(fields[5], "p")
+	i.Expires, err = parseIndexTime(fields[6])
+	if err != nil {
+		return
+	}
+	maxIdle, err := strconv.ParseInt(fields[7], 10, 64)
+	if err != nil {
+		return
+	}
+	i.MaxIdle = time.Duration(maxIdle)
+	s, err := strconv.Unquote(fields[8])
+	if err != nil {
+		return
+	}
+	k = sanitizePath(s)
+	if k == "" {
+		err = errIndexCorrupt
+	}
+	return
+}
+
+// Replays the index log into items. A truncated final record is tolerated, as that's what a crash
+// mid-append looks like. Anything else unexpected is reported as corruption.
+func readIndex(r io.Reader, items map[key]itemState) (records int, err error) {
+	br := bufio.NewReader(r)
+	header, err := br.ReadString('\n')
+	if err != nil || header != indexHeader+"\n" {
+		err = errIndexCorrupt
+		return
+	}
+	for {
+		var line string
+		line, err = br.ReadString('\n')
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		op, k, i, parseErr := parseIndexRecord(line[:len(line)-1])
+		if parseErr != nil {
+			err = fmt.Errorf("%w: record %d: %v", errIndexCorrupt, records, parseErr)
+			return
+		}
+		records++
+		switch op {
+		case '+':
+			items[k] = i
+		case '-':
+			delete(items, k)
+		}
+	}
+}
+
+// Writes a snapshot of items to a fresh index, and leaves it open for appending.
+func writeIndex(path string, items map[key]itemState) (ret *index, err error) {
+	err = os.MkdirAll(filepath.Dir(path), dirPerm)
+	if err != nil {
+		return
+	}
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, filePerm)
+	if err != nil {
+		return
+	}
+	ret = &index{
+		path: path,
+		f:    f,
+		w:    bufio.NewWriter(f),
+	}
+	_, err = fmt.Fprintln(ret.w, indexHeader)
+	for k, i := range items {
+		if err != nil {
+			break
+		}
+		err = ret.writeRecord('+', k, i)
+	}
+	if err == nil {
+		err = ret.w.Flush()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		ret = nil
+	}
+	return
+}
+
+func (me *Cache) indexPath() string {
+	return filepath.Join(me.metaDir(), indexFileName)
+}
+
+// Loads items from the index. On success the index is compacted and left open for appending.
+func (me *Cache) loadIndex() (err error) {
+	f, err := os.Open(me.indexPath())
+	if err != nil {
+		return
+	}
+	items := make(map[key]itemState)
+	_, err = readIndex(f, items)
+	f.Close()
+	if err != nil {
+		return
+	}
+	me.index, err = writeIndex(me.indexPath(), items)
+	if err != nil {
+		return
+	}
+	for k, i := range items {
+		i.setIndexed()
+		if _, ok := me.objects[i.Hash]; !ok {
+			i.Hash = ""
+		}
+		me.items[k] = i
+		me.account(k, i)
+		me.policyUsed(k, i)
+	}
+	return
+}
+
+// Whether the item has changed enough since it was last indexed to write a new record. Changes to
+// the size and completed ranges from writes are batched like access times, and written when the
+// file is closed.
+func indexRecordChanged(prev, cur itemState, batched bool) bool {
+	if prev.Hash != cur.Hash ||
+		prev.Pinned != cur.Pinned ||
+		!prev.Expires.Equal(cur.Expires) ||
+		prev.MaxIdle != cur.MaxIdle ||
+		cur.Accessed.Sub(prev.Accessed) >= indexAccessGranularity {
+		return true
+	}
+	return !batched && (prev.Size != cur.Size || !prev.Completed.equal(cur.Completed))
+}
+
+// Records the change to an item in the index, if there is one.
+func (me *Cache) indexItem(k key, i *itemState, existed, exists, batched bool) {
+	if me.index == nil {
+		return
+	}
+	var err error
+	switch {
+	case !exists:
+		if !existed {
+			return
+		}
+		err = me.index.appendRecord('-', k, *i)
+	case !existed || i.Indexed == nil || indexRecordChanged(*i.Indexed, *i, batched):
+		err = me.index.appendRecord('+', k, *i)
+		i.setIndexed()
+	default:
+		return
+	}
+	if err != nil {
+		me.dropIndex(err)
+	}
+}
+
+// Writes the changes batched for the item by writes.
+func (me *Cache) flushIndexItem(k key) {
+	me.updateItem(k, func(i *itemState, ok bool) bool {
+		return ok
+	})
+}
+
+// Rewrites the index from the current items once it's mostly superseded records.
+func (me *Cache) maybeCompactIndex() {
+	if me.index == nil || me.index.records <= 2*len(me.items)+1024 {
+		return
+	}
+	me.index.close()
+	var err error
+	me.index, err = writeIndex(me.indexPath(), me.items)
+	if err != nil {
+		me.dropIndex(err)
+	}
+}
+
+// Stops maintaining the index after an error. It's removed so that the next start does a full
+// rescan rather than trust stale data.
+func (me *Cache) dropIndex(err error) {
+	log.Printf("dropping index: %v", err)
+	if me.index != nil {
+		me.index.close()
+		me.index = nil
+	}
+	os.Remove(me.indexPath())
+}
+
+func (me *Cache) reconcile() {
+	defer close(me.reconciled)
+	err := me.refresh()
+	if err != nil && err != errCacheClosed {
+		log.Printf("error reconciling index: %v", err)
+	}
+}
+
+// Walks the tree without holding the lock for long, adding items that weren't known, refreshing
+// those that aren't verified, and finally forgetting any that weren't found.
+func (me *Cache) refresh() error {
+	err := me.walkFiles(func(k key) error {
+		me.mu.Lock()
+		defer me.mu.Unlock()
+		if me.closed {
+			return errCacheClosed
+		}
+		me.updateItem(k, func(i *itemState, ok bool) bool {
+			if ok && i.Verified {
+				return true
+			}
+			if !ok {
+				*i, ok = me.statKey(k)
+				return ok
+			}
+			return me.restatKey(k, i)
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for k, i := range me.items {
+		if !i.Verified {
+			me.updateItem(k, func(*itemState, bool) bool { return false })
+		}
+	}
+	me.removeOrphanObjects()
+	return nil
+}
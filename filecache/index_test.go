@@ -0,0 +1,144 @@
+package filecache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCacheFile(t *testing.T, c *Cache, path, contents string) {
+	f, err := c.OpenFile(path, os.O_CREATE|os.O_WRONLY)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestIndexReplay(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	writeCacheFile(t, c, "a", "hello")
+	writeCacheFile(t, c, "dir/b", "world!")
+	require.NoError(t, c.Remove("dir/b"))
+	writeCacheFile(t, c, "c", "herp")
+	require.NoError(t, c.Close())
+	assert.FileExists(t, filepath.Join(td, metaDirName, indexFileName))
+
+	// Change the tree behind the cache's back.
+	require.NoError(t, os.Remove(filepath.Join(td, "c")))
+	require.NoError(t, os.WriteFile(filepath.Join(td, "d"), []byte("derp"), filePerm))
+
+	c, err = NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	defer c.Close()
+	<-c.reconciled
+	assert.EqualValues(t, CacheInfo{
+		Capacity: -1,
+		Filled:   9,
+		NumItems: 2,
+	}, c.Info())
+	c.mu.Lock()
+	assert.Contains(t, c.items, key("a"))
+	assert.Contains(t, c.items, key("d"))
+	c.mu.Unlock()
+}
+
+func TestIndexCorruptRescans(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	writeCacheFile(t, c, "a", "hello")
+	require.NoError(t, c.Close())
+	indexPath := filepath.Join(td, metaDirName, indexFileName)
+	require.NoError(t, os.WriteFile(indexPath, []byte("garbage\n"), filePerm))
+
+	c, err = NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	defer c.Close()
+	assert.EqualValues(t, CacheInfo{
+		Capacity: -1,
+		Filled:   5,
+		NumItems: 1,
+	}, c.Info())
+	// The index was rewritten from the rescan.
+	b, err := os.ReadFile(indexPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"a"`)
+}
+
+func TestIndexTruncatedRecord(t *testing.T) {
+	items := make(map[key]itemState)
+	_, err := readIndex(
+		strings.NewReader(indexHeader+"\n+ 5 0 * - - - 0 \"a\"\n+ 3 0 * - - - 0 \"b"),
+		items)
+	require.NoError(t, err)
+	assert.Len(t, items, 1)
+	_, err = readIndex(strings.NewReader(indexHeader+"\n+ x 0 * - - - 0 \"a\"\n+ 3 0 * - - - 0 \"b\"\n"), items)
+	assert.ErrorIs(t, err, errIndexCorrupt)
+}
+
+func TestMetaKeysRejected(t *testing.T) {
+	c, err := NewCacheOpts(t.TempDir(), CacheOpts{Index: true})
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.OpenFile(metaDirName+"/"+indexFileName, os.O_RDONLY)
+	assert.ErrorIs(t, err, ErrBadPath)
+	assert.ErrorIs(t, c.Remove(metaDirName), ErrBadPath)
+}
+
+func TestIndexKeepsCompletedRanges(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	f, err := c.OpenFile("a", os.O_CREATE|os.O_WRONLY)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("world"), 10)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, c.Close())
+
+	c, err = NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	defer c.Close()
+	<-c.reconciled
+	ii, ok := c.Item("a")
+	require.True(t, ok)
+	assert.Equal(t, []Range{{10, 15}}, ii.Completed)
+}
+
+func TestIndexBatchesWrites(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	c.mu.Lock()
+	records := c.index.records
+	c.mu.Unlock()
+	f, err := c.OpenFile("a", os.O_CREATE|os.O_WRONLY)
+	require.NoError(t, err)
+	b := make([]byte, 4096)
+	for range 500 {
+		_, err = f.Write(b)
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+	// One record for the open, and one for the writes when it's closed.
+	c.mu.Lock()
+	assert.Equal(t, records+2, c.index.records)
+	c.mu.Unlock()
+	want, ok := c.Item("a")
+	require.True(t, ok)
+	require.NoError(t, c.Close())
+
+	c, err = NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	defer c.Close()
+	ii, ok := c.Item("a")
+	require.True(t, ok)
+	assert.EqualValues(t, 500*4096, ii.Size)
+	assert.Equal(t, want.Completed, ii.Completed)
+}
@@ -1,6 +1,7 @@
 package filecache
 
 import (
+	"math"
 	"os"
 	"time"
 
@@ -10,6 +11,90 @@ import (
 type itemState struct {
 	Accessed time.Time
 	Size     int64
+	// The item has been seen on disk since the cache was started. Items loaded from the index
+	// aren't verified until they're accessed or reconciled.
+	Verified bool
+	// The byte ranges that have been written. nil means the item is complete up to Size, which is
+	// assumed for items found on disk.
+	Completed ranges
+	// The object the item is a link to in dedup mode.
+	Hash   string
+	Pinned bool
+	// Set when the item has a TTL.
+	Expires time.Time
+	// Overrides the cache's default.
+	MaxIdle time.Duration
+	// The state last written to the index.
+	Indexed *itemState
+}
+
+func (i *itemState) setIndexed() {
+	indexed := *i
+	indexed.Indexed = nil
+	i.Indexed = &indexed
+}
+
+// Records that n bytes were written at off.
+func (i *itemState) wrote(off int64, n int) {
+	end := off + int64(n)
+	if i.Completed == nil && off > i.Size {
+		i.Completed = ranges{}.add(Range{0, i.Size})
+	}
+	if end > i.Size {
+		i.Size = end
+	}
+	if i.Completed == nil {
+		return
+	}
+	i.Completed = i.Completed.add(Range{off, end})
+	if len(i.Completed) == 1 && i.Completed[0] == (Range{0, i.Size}) {
+		i.Completed = nil
+	}
+}
+
+// Records that the item was resized without being written to. Growing it leaves a hole.
+func (i *itemState) truncated(size int64) {
+	if size > i.Size {
+		if i.Completed == nil {
+			i.Completed = ranges{}.add(Range{0, i.Size})
+		}
+	} else if i.Completed != nil {
+		clipped := ranges{}
+		for _, r := range i.Completed {
+			if r.Start >= size {
+				break
+			}
+			clipped = clipped.add(Range{r.Start, min(r.End, size)})
+		}
+		i.Completed = clipped
+	}
+	i.Size = size
+	if size == 0 || len(i.Completed) == 1 && i.Completed[0] == (Range{0, size}) {
+		i.Completed = nil
+	}
+}
+
+// Returns the end of the written data starting at off. If it runs to the end of the item, reads
+// aren't limited, so they find EOF rather than a hole.
+func (i *itemState) completedFrom(off int64) int64 {
+	if i.Completed == nil || off >= i.Size {
+		return math.MaxInt64
+	}
+	end := i.Completed.contiguousFrom(off)
+	if end >= i.Size {
+		return math.MaxInt64
+	}
+	return end
+}
+
+func (i *itemState) completedRanges() []Range {
+	if i.Completed != nil {
+		return append([]Range(nil), i.Completed...)
+	}
+	if i.Size == 0 {
+		return nil
+	}
+	return []Range{{0, i.Size}}
 }
 
 func (i *itemState) FromOSFileInfo(fi os.FileInfo) {
@@ -0,0 +1,77 @@
+package filecache
+
+import (
+	"time"
+
+	"github.com/anacrolix/missinggo/orderedmap"
+)
+
+// Evicts the least frequently used item, with ties going to the least recently used.
+type lfu struct {
+	o     orderedmap.OrderedMap
+	oKeys map[policyItemKey]lfuKey
+}
+
+type lfuKey struct {
+	item policyItemKey
+	uses int64
+	used time.Time
+}
+
+func (me lfuKey) Before(other lfuKey) bool {
+	if me.uses != other.uses {
+		return me.uses < other.uses
+	}
+	if !me.used.Equal(other.used) {
+		return me.used.Before(other.used)
+	}
+	return me.item.Before(other.item)
+}
+
+var _ Policy = (*lfu)(nil)
+
+func NewLfuPolicy() Policy {
+	return new(lfu)
+}
+
+func (me *lfu) Choose() (ret policyItemKey) {
+	any := false
+	me.o.Iter(func(i interface{}) bool {
+		ret = i.(lfuKey).item
+		any = true
+		return false
+	})
+	if !any {
+		panic("cache empty")
+	}
+	return
+}
+
+func (me *lfu) Used(k policyItemKey, at time.Time) {
+	if me.o == nil {
+		me.o = orderedmap.NewGoogleBTree(func(l, r interface{}) bool {
+			return l.(lfuKey).Before(r.(lfuKey))
+		})
+	}
+	if me.oKeys == nil {
+		me.oKeys = make(map[policyItemKey]lfuKey)
+	}
+	lk, ok := me.oKeys[k]
+	if ok {
+		me.o.Unset(lk)
+	}
+	lk = lfuKey{k, lk.uses + 1, at}
+	me.o.Set(lk, lk)
+	me.oKeys[k] = lk
+}
+
+func (me *lfu) Forget(k policyItemKey) {
+	if lk, ok := me.oKeys[k]; ok {
+		me.o.Unset(lk)
+	}
+	delete(me.oKeys, k)
+}
+
+func (me *lfu) NumItems() int {
+	return len(me.oKeys)
+}
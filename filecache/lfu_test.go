@@ -0,0 +1,23 @@
+package filecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLFU(t *testing.T) {
+	testPolicy(t, NewLfuPolicy())
+}
+
+func TestLfuPrefersFrequentItems(t *testing.T) {
+	p := NewLfuPolicy()
+	now := time.Now()
+	p.Used(key("a"), now)
+	p.Used(key("a"), now.Add(1))
+	p.Used(key("b"), now.Add(2))
+	assert.Equal(t, key("b"), p.Choose())
+	p.Used(key("b"), now.Add(3))
+	assert.Equal(t, key("a"), p.Choose())
+}
@@ -25,6 +25,10 @@ func (me lruKey) Before(other lruKey) bool {
 
 var _ Policy = (*lru)(nil)
 
+func NewLruPolicy() Policy {
+	return new(lru)
+}
+
 func (me *lru) Choose() (ret policyItemKey) {
 	any := false
 	me.o.Iter(func(i interface{}) bool {
@@ -64,3 +68,8 @@ func (me *lru) Forget(k policyItemKey) {
 func (me *lru) NumItems() int {
 	return len(me.oKeys)
 }
+
+func (me *lru) has(k policyItemKey) bool {
+	_, ok := me.oKeys[k]
+	return ok
+}
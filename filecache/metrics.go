@@ -0,0 +1,81 @@
+package filecache
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Counters for the cache's Prometheus metrics.
+type stats struct {
+	hits, misses            atomic.Int64
+	bytesRead, bytesWritten atomic.Int64
+	evictions               [numEvictReasons]atomic.Int64
+	lastInitDurationNanos   atomic.Int64
+}
+
+const numEvictReasons = int(EvictForced) + 1
+
+type metricDescs struct {
+	hits, misses            *prometheus.Desc
+	bytesRead, bytesWritten *prometheus.Desc
+	evictions               *prometheus.Desc
+	items, filled, capacity *prometheus.Desc
+	initDuration            *prometheus.Desc
+}
+
+// Metrics from different caches are distinguished by a root label.
+func newMetricDescs(root string) *metricDescs {
+	labels := prometheus.Labels{"root": root}
+	desc := func(name, help string, variableLabels ...string) *prometheus.Desc {
+		return prometheus.NewDesc("filecache_"+name, help, variableLabels, labels)
+	}
+	return &metricDescs{
+		hits:         desc("hits_total", "Opens for reading of items that were in the cache."),
+		misses:       desc("misses_total", "Opens for reading of items that weren't in the cache."),
+		bytesRead:    desc("read_bytes_total", "Bytes read from items."),
+		bytesWritten: desc("written_bytes_total", "Bytes written to items."),
+		evictions:    desc("evictions_total", "Items removed by the cache.", "reason"),
+		items:        desc("items", "Items in the cache."),
+		filled:       desc("filled_bytes", "Bytes used by items in the cache."),
+		capacity:     desc("capacity_bytes", "The cache capacity, or -1 if unlimited."),
+		initDuration: desc("rescan_duration_seconds", "Time taken by the last load or rescan of the cache state."),
+	}
+}
+
+var _ prometheus.Collector = (*Cache)(nil)
+
+// Describe implements prometheus.Collector.
+func (me *Cache) Describe(ch chan<- *prometheus.Desc) {
+	d := me.descs
+	for _, desc := range []*prometheus.Desc{
+		d.hits, d.misses, d.bytesRead, d.bytesWritten, d.evictions, d.items, d.filled, d.capacity,
+		d.initDuration,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (me *Cache) Collect(ch chan<- prometheus.Metric) {
+	d := me.descs
+	s := &me.stats
+	counter := func(desc *prometheus.Desc, v int64, labelValues ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), labelValues...)
+	}
+	gauge := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+	}
+	counter(d.hits, s.hits.Load())
+	counter(d.misses, s.misses.Load())
+	counter(d.bytesRead, s.bytesRead.Load())
+	counter(d.bytesWritten, s.bytesWritten.Load())
+	for reason := EvictCapacity; int(reason) < numEvictReasons; reason++ {
+		counter(d.evictions, s.evictions[reason].Load(), reason.String())
+	}
+	info := me.Info()
+	gauge(d.items, float64(info.NumItems))
+	gauge(d.filled, float64(info.Filled))
+	gauge(d.capacity, float64(info.Capacity))
+	gauge(d.initDuration, float64(s.lastInitDurationNanos.Load())/1e9)
+}
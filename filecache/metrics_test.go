@@ -0,0 +1,71 @@
+package filecache
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gatherMetrics(t *testing.T, c *Cache) map[string]float64 {
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(c))
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	ret := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			name := mf.GetName()
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "reason" {
+					name += "/" + lp.GetValue()
+				}
+			}
+			switch {
+			case m.Counter != nil:
+				ret[name] = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				ret[name] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	return ret
+}
+
+func TestMetrics(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetCapacity(8)
+	writeCacheFile(t, c, "a", "hello")
+	_, err = c.OpenFile("b", os.O_RDONLY)
+	require.True(t, os.IsNotExist(err))
+	f, err := c.OpenFile("a", os.O_RDONLY)
+	require.NoError(t, err)
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.EqualValues(t, "hello", b)
+	f.Close()
+	// Writes to staging files count too.
+	f, err = c.OpenFileOpts("c", os.O_CREATE|os.O_WRONLY, OpenOpts{Atomic: true})
+	require.NoError(t, err)
+	_, err = f.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, f.Commit())
+	c.TrimToCapacity()
+	m := gatherMetrics(t, c)
+	// Only opens for reading count as hits or misses.
+	assert.EqualValues(t, 1, m["filecache_hits_total"])
+	assert.EqualValues(t, 1, m["filecache_misses_total"])
+	assert.EqualValues(t, 5, m["filecache_read_bytes_total"])
+	assert.EqualValues(t, 10, m["filecache_written_bytes_total"])
+	assert.EqualValues(t, 1, m["filecache_evictions_total/capacity"])
+	assert.EqualValues(t, 0, m["filecache_evictions_total/quota"])
+	assert.EqualValues(t, 1, m["filecache_items"])
+	assert.EqualValues(t, 5, m["filecache_filled_bytes"])
+	assert.EqualValues(t, 8, m["filecache_capacity_bytes"])
+	assert.Contains(t, m, "filecache_rescan_duration_seconds")
+}
@@ -12,3 +12,18 @@ type Policy interface {
 	Forget(k policyItemKey)
 	NumItems() int
 }
+
+// Implemented by policies that take item sizes into account. Sized is called before each Used, and
+// whenever an item's size changes between uses.
+type SizedPolicy interface {
+	Policy
+	Sized(k policyItemKey, size int64)
+}
+
+// Implemented by policies that track evictions. Evicted is called with an item the policy chose
+// before it's removed to make room, and so before Forget. Items removed for other reasons are only
+// forgotten.
+type EvictingPolicy interface {
+	Policy
+	Evicted(k policyItemKey)
+}
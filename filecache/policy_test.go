@@ -1,10 +1,14 @@
 package filecache
 
 import (
+	"io"
+	"os"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func testChooseForgottenKey(t *testing.T, p Policy) {
@@ -22,3 +26,49 @@ func testChooseForgottenKey(t *testing.T, p Policy) {
 func testPolicy(t *testing.T, p Policy) {
 	testChooseForgottenKey(t, p)
 }
+
+// Counts the uses the policy is told about.
+type countingPolicy struct {
+	Policy
+	uses map[policyItemKey]int
+}
+
+func (me *countingPolicy) Used(k policyItemKey, at time.Time) {
+	me.uses[k]++
+	me.Policy.Used(k, at)
+}
+
+// Items are used once each time they're opened, however many reads and writes follow.
+func TestPolicyUsedPerOpen(t *testing.T) {
+	p := &countingPolicy{NewLfuPolicy(), make(map[policyItemKey]int)}
+	c, err := NewCacheOpts(t.TempDir(), CacheOpts{Policy: func() Policy { return p }})
+	require.NoError(t, err)
+	defer c.Close()
+	f, err := c.OpenFile("big", os.O_CREATE|os.O_WRONLY)
+	require.NoError(t, err)
+	for range 16 {
+		_, err = f.Write(make([]byte, 64<<10))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+	f, err = c.OpenFile("big", os.O_RDONLY)
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, iotest.OneByteReader(io.LimitReader(f, 1000)))
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, 2, p.uses[key("big")])
+	for range 10 {
+		f, err := c.OpenFile("small", os.O_CREATE|os.O_RDWR)
+		require.NoError(t, err)
+		_, err = f.Write([]byte("a"))
+		require.NoError(t, err)
+		_, err = f.ReadAt(make([]byte, 1), 0)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	assert.Equal(t, 10, p.uses[key("small")])
+	// The small item is used more often, so the big one goes first.
+	assert.Equal(t, key("big"), p.Choose())
+}
@@ -0,0 +1,85 @@
+package filecache
+
+import (
+	"os"
+	"strings"
+)
+
+// Namespaces are the top-level directories of the cache. Items at the top level are in the ""
+// namespace. A namespace with a quota is trimmed on its own, evicting only its own items, in
+// addition to the cache-wide capacity. For namespaces to be isolated from each other, the quotas
+// should sum to no more than the capacity.
+
+type quota struct {
+	limit int64
+	// Tracks only the items in the namespace.
+	policy Policy
+}
+
+type QuotaInfo struct {
+	Quota  int64
+	Filled int64
+}
+
+func namespaceOf(k key) string {
+	if i := strings.IndexByte(string(k), '/'); i >= 0 {
+		return string(k[:i])
+	}
+	return ""
+}
+
+// Sets the maximum number of bytes used by items in the namespace. A negative quota removes it.
+func (me *Cache) SetQuota(namespace string, limit int64) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.setQuota(namespace, limit)
+	me.trimToCapacity()
+}
+
+func (me *Cache) setQuota(namespace string, limit int64) {
+	if limit < 0 {
+		delete(me.quotas, namespace)
+		return
+	}
+	if q, ok := me.quotas[namespace]; ok {
+		q.limit = limit
+		return
+	}
+	q := &quota{
+		limit:  limit,
+		policy: me.newPolicy(),
+	}
+	for k, i := range me.items {
+		if namespaceOf(k) == namespace && !i.Pinned {
+			policyUsed(q.policy, k, i)
+		}
+	}
+	if me.quotas == nil {
+		me.quotas = make(map[string]*quota)
+	}
+	me.quotas[namespace] = q
+}
+
+// Pinned items are never evicted, but can still be removed. Returns an error satisfying
+// os.IsNotExist if the item isn't in the cache.
+func (me *Cache) Pin(path string) error {
+	return me.setPinned(path, true)
+}
+
+func (me *Cache) Unpin(path string) error {
+	return me.setPinned(path, false)
+}
+
+func (me *Cache) setPinned(path string, pinned bool) (err error) {
+	k := sanitizePath(path)
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, ok := me.items[k]; !ok {
+		return os.ErrNotExist
+	}
+	me.updateItem(k, func(i *itemState, ok bool) bool {
+		i.Pinned = pinned
+		return ok
+	})
+	return
+}
@@ -0,0 +1,91 @@
+package filecache
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinnedItemsNotEvicted(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	writeCacheFile(t, c, "a", "hello")
+	writeCacheFile(t, c, "b", "world")
+	require.NoError(t, c.Pin("a"))
+	assert.True(t, os.IsNotExist(c.Pin("c")))
+	c.SetCapacity(5)
+	c.TrimToCapacity()
+	assert.EqualValues(t, CacheInfo{
+		Capacity:     5,
+		Filled:       5,
+		NumItems:     1,
+		NumPinned:    1,
+		PinnedFilled: 5,
+	}, c.Info())
+	// Everything left is pinned, so the cache stays over capacity.
+	writeCacheFile(t, c, "c", "herp")
+	c.mu.Lock()
+	assert.Contains(t, c.items, key("a"))
+	assert.NotContains(t, c.items, key("c"))
+	c.mu.Unlock()
+	// Growing a pinned item is counted.
+	f, err := c.OpenFile("a", os.O_WRONLY)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("!"), 5)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	info := c.Info()
+	assert.Equal(t, 1, info.NumPinned)
+	assert.EqualValues(t, 6, info.PinnedFilled)
+	require.NoError(t, c.Unpin("a"))
+	info = c.Info()
+	assert.Equal(t, 0, info.NumPinned)
+	assert.EqualValues(t, 0, info.PinnedFilled)
+	writeCacheFile(t, c, "d", "derp")
+	ii, ok := c.Item("d")
+	require.True(t, ok)
+	assert.False(t, ii.Pinned)
+	_, ok = c.Item("a")
+	assert.False(t, ok)
+}
+
+func TestNamespaceQuotas(t *testing.T) {
+	c, err := NewCacheOpts(t.TempDir(), CacheOpts{
+		Quotas: map[string]int64{"tenant1": 8},
+	})
+	require.NoError(t, err)
+	writeCacheFile(t, c, "tenant2/a", "hello")
+	writeCacheFile(t, c, "tenant1/a", "hello")
+	writeCacheFile(t, c, "tenant1/b", "world")
+	info := c.Info()
+	assert.EqualValues(t, 10, info.Filled)
+	assert.Equal(t, map[string]QuotaInfo{"tenant1": {Quota: 8, Filled: 5}}, info.Quotas)
+	_, ok := c.Item("tenant1/a")
+	assert.False(t, ok)
+	_, ok = c.Item("tenant2/a")
+	assert.True(t, ok)
+
+	c.SetQuota("tenant2", 0)
+	_, ok = c.Item("tenant2/a")
+	assert.False(t, ok)
+	c.SetQuota("tenant2", -1)
+	assert.Len(t, c.Info().Quotas, 1)
+}
+
+func TestIndexKeepsPins(t *testing.T) {
+	td := t.TempDir()
+	c, err := NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	writeCacheFile(t, c, "a", "hello")
+	require.NoError(t, c.Pin("a"))
+	require.NoError(t, c.Close())
+	c, err = NewCacheOpts(td, CacheOpts{Index: true})
+	require.NoError(t, err)
+	defer c.Close()
+	<-c.reconciled
+	ii, ok := c.Item("a")
+	require.True(t, ok)
+	assert.True(t, ii.Pinned)
+}
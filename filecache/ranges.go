@@ -0,0 +1,96 @@
+package filecache
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A half-open range of byte offsets within an item.
+type Range struct {
+	Start, End int64
+}
+
+func (me Range) String() string {
+	return fmt.Sprintf("%d-%d", me.Start, me.End)
+}
+
+// A set of byte ranges. Kept sorted, with no overlapping or adjacent elements.
+type ranges []Range
+
+func (rs ranges) add(r Range) ranges {
+	if r.End <= r.Start {
+		return rs
+	}
+	// The first range that could touch r.
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].End >= r.Start })
+	// The first range after r that doesn't touch it.
+	j := i
+	for j < len(rs) && rs[j].Start <= r.End {
+		if rs[j].Start < r.Start {
+			r.Start = rs[j].Start
+		}
+		if rs[j].End > r.End {
+			r.End = rs[j].End
+		}
+		j++
+	}
+	ret := make(ranges, 0, len(rs)-(j-i)+1)
+	ret = append(ret, rs[:i]...)
+	ret = append(ret, r)
+	return append(ret, rs[j:]...)
+}
+
+// Returns the end of the range containing off, or off if it's not present.
+func (rs ranges) contiguousFrom(off int64) int64 {
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].End > off })
+	if i == len(rs) || rs[i].Start > off {
+		return off
+	}
+	return rs[i].End
+}
+
+func (rs ranges) String() string {
+	ss := make([]string, 0, len(rs))
+	for _, r := range rs {
+		ss = append(ss, r.String())
+	}
+	return strings.Join(ss, ",")
+}
+
+func parseRanges(s string) (ret ranges, err error) {
+	if s == "" {
+		return ranges{}, nil
+	}
+	for _, rs := range strings.Split(s, ",") {
+		first, last, ok := strings.Cut(rs, "-")
+		if !ok {
+			err = fmt.Errorf("bad range %q", rs)
+			return
+		}
+		var r Range
+		r.Start, err = strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			return
+		}
+		r.End, err = strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return
+		}
+		ret = ret.add(r)
+	}
+	return
+}
+
+func (rs ranges) equal(other ranges) bool {
+	if (rs == nil) != (other == nil) || len(rs) != len(other) {
+		return false
+	}
+	for i := range rs {
+		if rs[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package filecache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangesAdd(t *testing.T) {
+	var rs ranges
+	rs = rs.add(Range{10, 20})
+	rs = rs.add(Range{30, 40})
+	rs = rs.add(Range{0, 5})
+	assert.Equal(t, ranges{{0, 5}, {10, 20}, {30, 40}}, rs)
+	// Adjacent ranges are merged.
+	rs = rs.add(Range{5, 10})
+	assert.Equal(t, ranges{{0, 20}, {30, 40}}, rs)
+	// Spanning several ranges.
+	rs = rs.add(Range{15, 50})
+	assert.Equal(t, ranges{{0, 50}}, rs)
+	// Empty ranges are ignored.
+	assert.Equal(t, ranges{{0, 50}}, rs.add(Range{60, 60}))
+}
+
+func TestRangesContiguousFrom(t *testing.T) {
+	rs := ranges{{0, 5}, {10, 20}}
+	assert.EqualValues(t, 5, rs.contiguousFrom(0))
+	assert.EqualValues(t, 5, rs.contiguousFrom(4))
+	assert.EqualValues(t, 5, rs.contiguousFrom(5))
+	assert.EqualValues(t, 7, rs.contiguousFrom(7))
+	assert.EqualValues(t, 20, rs.contiguousFrom(10))
+	assert.EqualValues(t, 25, rs.contiguousFrom(25))
+}
+
+func TestParseRanges(t *testing.T) {
+	rs := ranges{{0, 5}, {10, 20}}
+	parsed, err := parseRanges(rs.String())
+	require.NoError(t, err)
+	assert.Equal(t, rs, parsed)
+	_, err = parseRanges("1-x")
+	assert.Error(t, err)
+}
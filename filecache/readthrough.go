@@ -0,0 +1,133 @@
+package filecache
+
+import (
+	"io"
+	"os"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/missinggo/v2"
+	"github.com/anacrolix/missinggo/v2/resource"
+)
+
+// Serves resources from the cache, fetching them from an upstream Provider on a miss. Concurrent
+// misses for the same location are collapsed into a single fetch. Writes go to the upstream, and
+// invalidate the cached copy.
+type ReadThroughProvider struct {
+	Cache    *Cache
+	Upstream resource.Provider
+	// Maps locations to paths in the cache. The default uses the location as is.
+	CachePath func(location string) string
+	sf        missinggo.SingleFlight
+}
+
+var _ resource.Provider = &ReadThroughProvider{}
+
+func (me *ReadThroughProvider) NewInstance(loc string) (resource.Instance, error) {
+	upstream, err := me.Upstream.NewInstance(loc)
+	if err != nil {
+		return nil, err
+	}
+	path := loc
+	if me.CachePath != nil {
+		path = me.CachePath(loc)
+	}
+	return &readThroughInstance{
+		p:        me,
+		path:     path,
+		upstream: upstream,
+	}, nil
+}
+
+type readThroughInstance struct {
+	p        *ReadThroughProvider
+	path     string
+	upstream resource.Instance
+}
+
+var _ resource.Instance = &readThroughInstance{}
+
+func (me *readThroughInstance) cached() bool {
+	ii, ok := me.p.Cache.Item(me.path)
+	return ok && ii.IsComplete()
+}
+
+// Ensures the resource is in the cache.
+func (me *readThroughInstance) fill() (err error) {
+	if me.cached() {
+		return
+	}
+	defer me.p.sf.Lock(me.path).Unlock()
+	// Someone else may have filled it while we waited.
+	if me.cached() {
+		return
+	}
+	rc, err := me.upstream.Get()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	f, err := me.p.Cache.OpenFileOpts(me.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, OpenOpts{Atomic: true})
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = io.Copy(f, rc)
+	if err != nil {
+		return
+	}
+	return f.Commit()
+}
+
+func (me *readThroughInstance) open() (f *File, err error) {
+	err = me.fill()
+	if err != nil {
+		return
+	}
+	return me.p.Cache.OpenFile(me.path, os.O_RDONLY)
+}
+
+func (me *readThroughInstance) Get() (io.ReadCloser, error) {
+	return me.open()
+}
+
+func (me *readThroughInstance) ReadAt(b []byte, off int64) (n int, err error) {
+	f, err := me.open()
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return f.ReadAt(b, off)
+}
+
+func (me *readThroughInstance) Stat() (os.FileInfo, error) {
+	if me.cached() {
+		fi, err := me.p.Cache.Stat(me.path)
+		if err == nil {
+			return fi, nil
+		}
+	}
+	return me.upstream.Stat()
+}
+
+func (me *readThroughInstance) invalidate() {
+	err := me.p.Cache.Remove(me.path)
+	if err != nil {
+		log.Printf("error invalidating %q: %v", me.path, err)
+	}
+}
+
+func (me *readThroughInstance) Put(r io.Reader) error {
+	defer me.invalidate()
+	return me.upstream.Put(r)
+}
+
+func (me *readThroughInstance) WriteAt(b []byte, off int64) (int, error) {
+	defer me.invalidate()
+	return me.upstream.WriteAt(b, off)
+}
+
+func (me *readThroughInstance) Delete() error {
+	defer me.invalidate()
+	return me.upstream.Delete()
+}
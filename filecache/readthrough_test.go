@@ -0,0 +1,88 @@
+package filecache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bradfitz/iter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/missinggo/v2/resource"
+)
+
+func TestReadThroughProvider(t *testing.T) {
+	var gets atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/a" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "GET" {
+			gets.Add(1)
+			<-release
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	defer c.Close()
+	p := &ReadThroughProvider{
+		Cache:    c,
+		Upstream: &resource.HTTPProvider{},
+		CachePath: func(loc string) string {
+			return loc[len(srv.URL):]
+		},
+	}
+
+	var wg sync.WaitGroup
+	for range iter.N(5) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// require can't stop the test from other goroutines.
+			i, err := p.NewInstance(srv.URL + "/a")
+			if !assert.NoError(t, err) {
+				return
+			}
+			rc, err := i.Get()
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer rc.Close()
+			b, err := io.ReadAll(rc)
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(b))
+		}()
+	}
+	close(release)
+	wg.Wait()
+	assert.EqualValues(t, 1, gets.Load())
+	assert.EqualValues(t, 5, c.Info().Filled)
+
+	i, err := p.NewInstance(srv.URL + "/a")
+	require.NoError(t, err)
+	b := make([]byte, 3)
+	n, err := i.ReadAt(b, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "llo", string(b[:n]))
+	assert.EqualValues(t, 1, gets.Load())
+
+	// Writes go upstream and invalidate the cached copy.
+	_, err = i.WriteAt([]byte("j"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Info().NumItems)
+
+	i, err = p.NewInstance(srv.URL + "/b")
+	require.NoError(t, err)
+	_, err = i.Get()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, 0, c.Info().NumItems)
+}
@@ -0,0 +1,348 @@
+package filecache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/missinggo/v2"
+	"github.com/anacrolix/missinggo/v2/cache"
+	"github.com/anacrolix/missinggo/v2/resource"
+)
+
+// Stores resources in a memory tier in front of a Cache. An item lives in one tier at a time. New
+// items go to memory if they're small enough, and are demoted to disk when the memory tier evicts
+// them. Items on disk are promoted back to memory once they've been read often enough.
+type TieredProvider struct {
+	disk         *Cache
+	mem          *cache.Cache[string, *memItem]
+	maxItemSize  int64
+	promoteAfter int
+	// Serializes operations on each path.
+	sf missinggo.SingleFlight
+
+	mu sync.Mutex
+	// Items evicted from memory that are still being written to disk.
+	demoting map[string]*memItem
+	// Reads of items on disk since they were last in memory.
+	diskHits map[string]int
+}
+
+type TieredOpts struct {
+	// The byte budget of the memory tier.
+	MemoryCapacity int64
+	// Items bigger than this are kept on disk. The default is an eighth of MemoryCapacity.
+	MaxMemoryItemSize int64
+	// The number of reads of an item on disk before it's promoted to memory. The default is 2.
+	PromoteAfter int
+	// The eviction policy for the memory tier. The default is LRU.
+	Policy func() cache.Policy[string]
+}
+
+// Limits the memory used to track reads of items on disk. The counts are reset when it's reached.
+const maxTrackedDiskHits = 1 << 16
+
+type memItem struct {
+	data    []byte
+	modTime time.Time
+}
+
+var _ resource.Provider = (*TieredProvider)(nil)
+
+func NewTieredProvider(disk *Cache, opts TieredOpts) *TieredProvider {
+	ret := &TieredProvider{
+		disk:         disk,
+		maxItemSize:  opts.MaxMemoryItemSize,
+		promoteAfter: opts.PromoteAfter,
+		demoting:     make(map[string]*memItem),
+		diskHits:     make(map[string]int),
+	}
+	if ret.maxItemSize == 0 {
+		ret.maxItemSize = opts.MemoryCapacity / 8
+	}
+	if ret.promoteAfter == 0 {
+		ret.promoteAfter = 2
+	}
+	ret.mem = cache.New(cache.Opts[string, *memItem]{
+		Capacity: opts.MemoryCapacity,
+		Policy:   opts.Policy,
+		OnEvict:  ret.demote,
+	})
+	return ret
+}
+
+func (me *TieredProvider) NewInstance(loc string) (resource.Instance, error) {
+	k := sanitizePath(loc)
+	if k == "" || isMetaKey(k) {
+		return nil, ErrBadPath
+	}
+	return &tieredInstance{me, string(k)}, nil
+}
+
+// Returns the number of items and bytes in the memory tier.
+func (me *TieredProvider) MemoryUsage() (items int, filled int64) {
+	return me.mem.NumItems(), me.mem.Filled()
+}
+
+// Writes an item evicted from memory to disk. It's done in the background, as the eviction may be
+// the result of an operation on another path.
+func (me *TieredProvider) demote(i cache.Item[string, *memItem]) {
+	me.mu.Lock()
+	me.demoting[i.Key] = i.Value
+	me.mu.Unlock()
+	go func() {
+		defer me.sf.Lock(i.Key).Unlock()
+		me.mu.Lock()
+		superseded := me.demoting[i.Key] != i.Value
+		me.mu.Unlock()
+		if !superseded {
+			err := me.putDisk(i.Key, i.Value.data)
+			if err != nil {
+				log.Printf("error demoting %q: %v", i.Key, err)
+			}
+		}
+		me.mu.Lock()
+		if me.demoting[i.Key] == i.Value {
+			delete(me.demoting, i.Key)
+		}
+		me.mu.Unlock()
+	}()
+}
+
+func (me *TieredProvider) putDisk(p string, data []byte) (err error) {
+	f, err := me.disk.OpenFileOpts(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, OpenOpts{Atomic: true})
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	if err != nil {
+		return
+	}
+	return f.Commit()
+}
+
+// Returns the item if it's in memory, including if it's on its way to disk.
+func (me *TieredProvider) memGet(p string) (*memItem, bool) {
+	if i, ok := me.mem.Get(p); ok {
+		return i, true
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	i, ok := me.demoting[p]
+	return i, ok
+}
+
+// Puts an item in memory, removing it from disk. Returns false if the memory tier's policy didn't
+// admit it, in which case the disk is left alone. Should be called with the path locked.
+func (me *TieredProvider) memPut(p string, i *memItem) bool {
+	me.mu.Lock()
+	delete(me.demoting, p)
+	me.mu.Unlock()
+	if !me.mem.Update(cache.Item[string, *memItem]{
+		Key:   p,
+		Value: i,
+		Size:  int64(len(i.data)),
+	}) {
+		return false
+	}
+	me.mu.Lock()
+	delete(me.diskHits, p)
+	me.mu.Unlock()
+	err := me.disk.Remove(p)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("error removing %q from disk: %v", p, err)
+	}
+	return true
+}
+
+// Puts an item in memory, or on disk if the memory tier doesn't admit it. Should be called with
+// the path locked.
+func (me *TieredProvider) put(p string, i *memItem) error {
+	if me.memPut(p, i) {
+		return nil
+	}
+	return me.putDisk(p, i.data)
+}
+
+// Removes the item from memory. Should be called with the path locked.
+func (me *TieredProvider) memRemove(p string) (ok bool) {
+	_, ok = me.mem.Remove(p)
+	me.mu.Lock()
+	if _, demoting := me.demoting[p]; demoting {
+		delete(me.demoting, p)
+		ok = true
+	}
+	me.mu.Unlock()
+	return
+}
+
+// Opens the item on disk, promoting it to memory if it's been read enough. Should be called with
+// the path locked.
+func (me *TieredProvider) openDisk(p string) (ret tieredReader, err error) {
+	f, err := me.disk.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return
+	}
+	if !me.shouldPromote(p) {
+		return f, nil
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return
+	}
+	i := &memItem{data: data, modTime: time.Now()}
+	// It's still on disk if it isn't admitted.
+	me.memPut(p, i)
+	return i.reader(), nil
+}
+
+func (me *TieredProvider) shouldPromote(p string) bool {
+	ii, ok := me.disk.Item(p)
+	if !ok || !ii.IsComplete() || ii.Size > me.maxItemSize {
+		return false
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if len(me.diskHits) >= maxTrackedDiskHits {
+		clear(me.diskHits)
+	}
+	me.diskHits[p]++
+	return me.diskHits[p] >= me.promoteAfter
+}
+
+type tieredInstance struct {
+	p    *TieredProvider
+	path string
+}
+
+var _ resource.Instance = (*tieredInstance)(nil)
+
+func (me *tieredInstance) open() (tieredReader, error) {
+	if i, ok := me.p.memGet(me.path); ok {
+		return i.reader(), nil
+	}
+	defer me.p.sf.Lock(me.path).Unlock()
+	// It may have been promoted while we waited.
+	if i, ok := me.p.memGet(me.path); ok {
+		return i.reader(), nil
+	}
+	return me.p.openDisk(me.path)
+}
+
+func (me *tieredInstance) Get() (io.ReadCloser, error) {
+	return me.open()
+}
+
+func (me *tieredInstance) ReadAt(b []byte, off int64) (n int, err error) {
+	r, err := me.open()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	return r.ReadAt(b, off)
+}
+
+func (me *tieredInstance) Put(r io.Reader) (err error) {
+	defer me.p.sf.Lock(me.path).Unlock()
+	// Read up to one byte more than fits in memory, to see if it does.
+	data, err := io.ReadAll(io.LimitReader(r, me.p.maxItemSize+1))
+	if err != nil {
+		return
+	}
+	if int64(len(data)) <= me.p.maxItemSize {
+		return me.p.put(me.path, &memItem{data: data, modTime: time.Now()})
+	}
+	me.p.memRemove(me.path)
+	f, err := me.p.disk.OpenFileOpts(me.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, OpenOpts{Atomic: true})
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = io.Copy(f, io.MultiReader(bytes.NewReader(data), r))
+	if err != nil {
+		return
+	}
+	return f.Commit()
+}
+
+func (me *tieredInstance) WriteAt(b []byte, off int64) (n int, err error) {
+	defer me.p.sf.Lock(me.path).Unlock()
+	i, ok := me.p.memGet(me.path)
+	if !ok || off+int64(len(b)) > me.p.maxItemSize {
+		if ok {
+			// It's outgrowing memory. Move it to disk before writing to it there.
+			me.p.memRemove(me.path)
+			err = me.p.putDisk(me.path, i.data)
+			if err != nil {
+				return
+			}
+		}
+		var f *File
+		f, err = me.p.disk.OpenFile(me.path, os.O_CREATE|os.O_WRONLY)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		return f.WriteAt(b, off)
+	}
+	// Memory items are never modified in place, as readers may still be using them.
+	data := make([]byte, max(int64(len(i.data)), off+int64(len(b))))
+	copy(data, i.data)
+	copy(data[off:], b)
+	err = me.p.put(me.path, &memItem{data: data, modTime: time.Now()})
+	if err == nil {
+		n = len(b)
+	}
+	return
+}
+
+func (me *tieredInstance) Stat() (os.FileInfo, error) {
+	if i, ok := me.p.memGet(me.path); ok {
+		return memFileInfo{path.Base(me.path), i}, nil
+	}
+	return me.p.disk.Stat(me.path)
+}
+
+func (me *tieredInstance) Delete() error {
+	defer me.p.sf.Lock(me.path).Unlock()
+	inMem := me.p.memRemove(me.path)
+	err := me.p.disk.Remove(me.path)
+	if inMem && os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
+
+type tieredReader interface {
+	io.ReadCloser
+	io.ReaderAt
+}
+
+type memReader struct {
+	*bytes.Reader
+}
+
+func (memReader) Close() error { return nil }
+
+func (me *memItem) reader() memReader {
+	return memReader{bytes.NewReader(me.data)}
+}
+
+type memFileInfo struct {
+	name string
+	i    *memItem
+}
+
+func (me memFileInfo) Name() string       { return me.name }
+func (me memFileInfo) Size() int64        { return int64(len(me.i.data)) }
+func (me memFileInfo) Mode() os.FileMode  { return filePerm }
+func (me memFileInfo) ModTime() time.Time { return me.i.modTime }
+func (me memFileInfo) IsDir() bool        { return false }
+func (me memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,137 @@
+package filecache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/missinggo/v2/cache"
+	"github.com/anacrolix/missinggo/v2/resource"
+)
+
+func tieredGet(t *testing.T, p resource.Provider, loc string) string {
+	i, err := p.NewInstance(loc)
+	require.NoError(t, err)
+	rc, err := i.Get()
+	require.NoError(t, err)
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func tieredPut(t *testing.T, p resource.Provider, loc, contents string) {
+	i, err := p.NewInstance(loc)
+	require.NoError(t, err)
+	require.NoError(t, i.Put(strings.NewReader(contents)))
+}
+
+func waitOnDisk(t *testing.T, c *Cache, path string) {
+	require.Eventually(t, func() bool {
+		ii, ok := c.Item(path)
+		return ok && ii.IsComplete()
+	}, time.Second, time.Millisecond)
+}
+
+func TestTieredDemoteAndPromote(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	defer c.Close()
+	p := NewTieredProvider(c, TieredOpts{
+		MemoryCapacity:    10,
+		MaxMemoryItemSize: 5,
+	})
+	tieredPut(t, p, "a", "hello")
+	tieredPut(t, p, "b", "world")
+	_, ok := c.Item("a")
+	assert.False(t, ok)
+	items, filled := p.MemoryUsage()
+	assert.Equal(t, 2, items)
+	assert.EqualValues(t, 10, filled)
+	// Pushes a out of memory.
+	tieredPut(t, p, "c", "herp")
+	waitOnDisk(t, c, "a")
+	assert.Equal(t, "hello", tieredGet(t, p, "a"))
+	_, ok = c.Item("a")
+	assert.True(t, ok)
+	// The second read from disk promotes it, which in turn demotes b.
+	assert.Equal(t, "hello", tieredGet(t, p, "a"))
+	_, ok = c.Item("a")
+	assert.False(t, ok)
+	waitOnDisk(t, c, "b")
+	assert.Equal(t, "world", tieredGet(t, p, "b"))
+}
+
+func TestTieredLargeItemsGoToDisk(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	defer c.Close()
+	p := NewTieredProvider(c, TieredOpts{MemoryCapacity: 80})
+	tieredPut(t, p, "big", "0123456789abcdef")
+	ii, ok := c.Item("big")
+	require.True(t, ok)
+	assert.EqualValues(t, 16, ii.Size)
+	assert.Equal(t, "0123456789abcdef", tieredGet(t, p, "big"))
+
+	i, err := p.NewInstance("small")
+	require.NoError(t, err)
+	_, err = i.WriteAt([]byte("abc"), 2)
+	require.NoError(t, err)
+	fi, err := i.Stat()
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, fi.Size())
+	b := make([]byte, 3)
+	_, err = i.ReadAt(b, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(b))
+	// Growing it past the memory item limit moves it to disk.
+	_, err = i.WriteAt(bytes.Repeat([]byte("x"), 10), 5)
+	require.NoError(t, err)
+	ii, ok = c.Item("small")
+	require.True(t, ok)
+	assert.EqualValues(t, 15, ii.Size)
+
+	require.NoError(t, i.Delete())
+	_, err = i.Stat()
+	assert.True(t, os.IsNotExist(err))
+	_, err = p.NewInstance("")
+	assert.ErrorIs(t, err, ErrBadPath)
+}
+
+// Items the memory tier's policy doesn't admit are kept on disk.
+func TestTieredNotAdmitted(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	require.NoError(t, err)
+	defer c.Close()
+	p := NewTieredProvider(c, TieredOpts{
+		MemoryCapacity:    10,
+		MaxMemoryItemSize: 5,
+		Policy: func() cache.Policy[string] {
+			return cache.NewTinyLfuPolicy[string](cache.NewLruPolicy[string](), 100)
+		},
+	})
+	tieredPut(t, p, "a", "hello")
+	tieredPut(t, p, "b", "world")
+	assert.Equal(t, "hello", tieredGet(t, p, "a"))
+	assert.Equal(t, "world", tieredGet(t, p, "b"))
+	tieredPut(t, p, "c", "herp")
+	items, _ := p.MemoryUsage()
+	assert.Equal(t, 2, items)
+	ii, ok := c.Item("c")
+	require.True(t, ok)
+	assert.True(t, ii.IsComplete())
+	assert.Equal(t, "herp", tieredGet(t, p, "c"))
+
+	// Writes that aren't admitted go to disk too.
+	i, err := p.NewInstance("d")
+	require.NoError(t, err)
+	_, err = i.WriteAt([]byte("derp"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "derp", tieredGet(t, p, "d"))
+}
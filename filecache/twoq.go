@@ -0,0 +1,70 @@
+package filecache
+
+import (
+	"time"
+)
+
+// The 2Q policy. New items enter a FIFO queue, and are only promoted to the main LRU queue if
+// they're used again after being evicted from it, while their key is still remembered in the ghost
+// queue. This stops a scan of items that are used once from flushing out the working set.
+type twoQueue struct {
+	in     lru
+	main   lru
+	ghosts lru
+	// When items entered the in queue. The in queue isn't reordered on use.
+	entered map[policyItemKey]time.Time
+}
+
+const (
+	// Target proportion of items in the in queue.
+	twoQueueInRatio = 4
+	// Maximum number of ghost keys, as a proportion of all items.
+	twoQueueGhostRatio = 2
+)
+
+var _ Policy = (*twoQueue)(nil)
+
+func New2QPolicy() Policy {
+	return new(twoQueue)
+}
+
+func (me *twoQueue) Choose() policyItemKey {
+	if me.main.NumItems() == 0 || me.in.NumItems() > me.NumItems()/twoQueueInRatio {
+		return me.in.Choose()
+	}
+	return me.main.Choose()
+}
+
+func (me *twoQueue) Used(k policyItemKey, at time.Time) {
+	switch {
+	case me.main.has(k):
+		me.main.Used(k, at)
+	case me.in.has(k):
+	case me.ghosts.has(k):
+		me.ghosts.Forget(k)
+		me.main.Used(k, at)
+	default:
+		me.in.Used(k, at)
+		if me.entered == nil {
+			me.entered = make(map[policyItemKey]time.Time)
+		}
+		me.entered[k] = at
+	}
+}
+
+func (me *twoQueue) Forget(k policyItemKey) {
+	if me.in.has(k) {
+		me.in.Forget(k)
+		me.ghosts.Used(k, me.entered[k])
+		delete(me.entered, k)
+		for me.ghosts.NumItems() > me.NumItems()/twoQueueGhostRatio+1 {
+			me.ghosts.Forget(me.ghosts.Choose())
+		}
+		return
+	}
+	me.main.Forget(k)
+}
+
+func (me *twoQueue) NumItems() int {
+	return me.in.NumItems() + me.main.NumItems()
+}
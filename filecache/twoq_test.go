@@ -0,0 +1,30 @@
+package filecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test2Q(t *testing.T) {
+	testPolicy(t, New2QPolicy())
+}
+
+func Test2QScanResistance(t *testing.T) {
+	p := New2QPolicy()
+	now := time.Now()
+	// Promote "hot" to the main queue by using it again after it's evicted from the in queue.
+	p.Used(key("hot"), now)
+	p.Used(key("x"), now)
+	assert.Equal(t, key("hot"), p.Choose())
+	p.Forget(key("hot"))
+	p.Used(key("hot"), now.Add(1))
+	// A scan of one-off keys shouldn't displace it.
+	for _, k := range []key{"s1", "s2", "s3", "s4", "s5"} {
+		p.Used(k, now.Add(2))
+		c := p.Choose()
+		assert.NotEqual(t, key("hot"), c)
+		p.Forget(c)
+	}
+}
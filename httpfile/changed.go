@@ -0,0 +1,63 @@
+package httpfile
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Returned when a read from an offset gets the whole of an unchanged resource back, because the
+// server doesn't support Range requests.
+var ErrRangeNotSupported = errors.New("server doesn't support ranges")
+
+// Returned by File reads when the resource changed after the File first read from it.
+type ChangedError struct {
+	URL string
+	// The ETag or Last-Modified of the version first read.
+	Validator string
+}
+
+func (me *ChangedError) Error() string {
+	return fmt.Sprintf("%s changed since it was first read (was %s)", me.URL, me.Validator)
+}
+
+func (me *File) changedError() error {
+	return &ChangedError{
+		URL:       me.url,
+		Validator: me.validator(),
+	}
+}
+
+// Returns the If-Range value for resuming a read of the same version, or "" if there's nothing
+// suitable. Weak ETags can't be used for If-Range.
+func (me *File) validator() string {
+	if me.etag != "" && !strings.HasPrefix(me.etag, "W/") {
+		return me.etag
+	}
+	return me.lastModified
+}
+
+// Returns the strong ETag or Last-Modified of the response, for use with If-Range.
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// Records the version of the resource in the first response, and returns false if a later response
+// is for a different one.
+func (me *File) checkValidator(resp *http.Response) bool {
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	if me.etag == "" && me.lastModified == "" {
+		me.etag = etag
+		me.lastModified = lastModified
+		return true
+	}
+	if me.etag != "" {
+		return etag == me.etag
+	}
+	return lastModified == me.lastModified
+}
@@ -0,0 +1,306 @@
+package httpfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/missinggo/httptoo"
+)
+
+const (
+	DefaultChunkSize       = 4 << 20
+	DefaultDownloadWorkers = 4
+)
+
+// A half-open range of byte offsets.
+type Range struct {
+	Start, End int64
+}
+
+type DownloadOpts struct {
+	// The most fetched by each request. Defaults to DefaultChunkSize.
+	ChunkSize int64
+	// The most requests in flight at once. Defaults to DefaultDownloadWorkers.
+	Workers int
+	// Ranges already in the destination from an earlier attempt. They aren't fetched again.
+	Completed []Range
+	// The validator returned by the attempt that wrote Completed. If the resource has changed
+	// since, a *ChangedError is returned rather than mixing versions in the destination.
+	Validator string
+	// Called as data is written, with the bytes completed so far and the total length. Calls aren't
+	// concurrent.
+	Progress func(done, total int64)
+}
+
+// Downloads the resource at url into dest, fetching ranges of it concurrently. Chunks that fail are
+// resumed from where they stopped, as File reads are. The ranges of the resource in dest, and the
+// validator of the version they're from, are returned even on error. They can be passed back as
+// DownloadOpts.Completed and DownloadOpts.Validator to resume.
+func (fs *FS) Download(ctx context.Context, url string, dest io.WriterAt, opts DownloadOpts) (completed []Range, validator string, err error) {
+	completed = mergeRanges(opts.Completed)
+	validator = opts.Validator
+	length, current, err := fs.headValidator(ctx, url)
+	if err != nil {
+		return
+	}
+	if validator == "" {
+		validator = current
+	} else if current != validator {
+		err = &ChangedError{URL: url, Validator: validator}
+		return
+	}
+	d := &download{
+		fs:        fs,
+		url:       url,
+		dest:      dest,
+		validator: validator,
+		total:     length,
+		progress:  opts.Progress,
+		completed: completed,
+	}
+	for _, r := range d.completed {
+		d.done += r.End - r.Start
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultDownloadWorkers
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	chunks := make(chan Range)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range chunks {
+				if err := d.fetchChunk(ctx, r); err != nil {
+					cancel(err)
+				}
+			}
+		}()
+	}
+feed:
+	for _, r := range splitRanges(missingRanges(d.completed, length), chunkSize) {
+		select {
+		case chunks <- r:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(chunks)
+	wg.Wait()
+	if ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+	completed = mergeRanges(d.completed)
+	return
+}
+
+type download struct {
+	fs        *FS
+	url       string
+	dest      io.WriterAt
+	validator string
+	total     int64
+	progress  func(done, total int64)
+
+	mu        sync.Mutex
+	done      int64
+	completed []Range
+}
+
+// Fetches a chunk, resuming it after failures until it's complete or out of retries.
+func (me *download) fetchChunk(ctx context.Context, r Range) (err error) {
+	attempt := 0
+	for {
+		var retry bool
+		var n int64
+		retry, n, err = me.fetchRange(ctx, r)
+		me.addCompleted(Range{r.Start, r.Start + n})
+		r.Start += n
+		if err == nil {
+			return
+		}
+		if retry && n != 0 && ctx.Err() == nil {
+			// Progress was made, so resume straight away and start counting retries again.
+			attempt = 0
+			continue
+		}
+		if !retry || attempt >= me.fs.readRetries() || ctx.Err() != nil {
+			return
+		}
+		select {
+		case <-time.After(me.fs.retryDelay(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		attempt++
+	}
+}
+
+// Copies the range into the destination, returning how much was written.
+func (me *download) fetchRange(ctx context.Context, r Range) (retry bool, n int64, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", me.url, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.Start, r.End-1))
+	if me.validator != "" {
+		req.Header.Set("If-Range", me.validator)
+	}
+	resp, err := me.fs.Client.Do(req)
+	if err != nil {
+		retry = true
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// If-Range didn't match, or the server ignored the Range.
+		if me.validator != "" && responseValidator(resp) != me.validator {
+			err = &ChangedError{URL: me.url, Validator: me.validator}
+		} else {
+			err = ErrRangeNotSupported
+		}
+		return
+	case http.StatusNotFound:
+		err = ErrNotFound
+		return
+	default:
+		err = errors.New(resp.Status)
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return
+	}
+	cr, ok := httptoo.ParseBytesContentRange(resp.Header.Get("Content-Range"))
+	if !ok || cr.First != r.Start || cr.Last != r.End-1 {
+		err = fmt.Errorf("bad Content-Range %q", resp.Header.Get("Content-Range"))
+		return
+	}
+	n, err = io.Copy(&downloadWriter{me, r.Start}, io.LimitReader(resp.Body, r.End-r.Start))
+	if err == nil && n != r.End-r.Start {
+		err = io.ErrUnexpectedEOF
+	}
+	retry = err != nil
+	return
+}
+
+func (me *download) addCompleted(r Range) {
+	if r.End <= r.Start {
+		return
+	}
+	me.mu.Lock()
+	me.completed = append(me.completed, r)
+	me.mu.Unlock()
+}
+
+func (me *download) wrote(n int) {
+	if me.progress == nil {
+		return
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.done += int64(n)
+	me.progress(me.done, me.total)
+}
+
+// Writes sequentially to the destination from an offset.
+type downloadWriter struct {
+	d   *download
+	off int64
+}
+
+func (me *downloadWriter) Write(b []byte) (n int, err error) {
+	n, err = me.d.dest.WriteAt(b, me.off)
+	me.off += int64(n)
+	me.d.wrote(n)
+	return
+}
+
+// Returns the length of the resource and a value for If-Range that identifies its current version.
+func (fs *FS) headValidator(ctx context.Context, url string) (length int64, validator string, err error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return
+	}
+	resp, err := fs.Client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		err = ErrNotFound
+		return
+	default:
+		err = errors.New(resp.Status)
+		return
+	}
+	length, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		err = fmt.Errorf("resource length unknown: %w", err)
+		return
+	}
+	validator = responseValidator(resp)
+	return
+}
+
+// Sorts the ranges, and joins those that overlap or touch.
+func mergeRanges(rs []Range) (ret []Range) {
+	rs = append([]Range(nil), rs...)
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Start < rs[j].Start })
+	for _, r := range rs {
+		if r.End <= r.Start {
+			continue
+		}
+		if len(ret) != 0 && r.Start <= ret[len(ret)-1].End {
+			last := &ret[len(ret)-1]
+			last.End = max(last.End, r.End)
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return
+}
+
+// Returns the ranges of [0, length) not in the merged ranges.
+func missingRanges(merged []Range, length int64) (ret []Range) {
+	var off int64
+	for _, r := range merged {
+		if r.Start > off {
+			ret = append(ret, Range{off, min(r.Start, length)})
+		}
+		off = max(off, r.End)
+		if off >= length {
+			return
+		}
+	}
+	if off < length {
+		ret = append(ret, Range{off, length})
+	}
+	return
+}
+
+func splitRanges(rs []Range, size int64) (ret []Range) {
+	for _, r := range rs {
+		for r.Start < r.End {
+			end := min(r.Start+size, r.End)
+			ret = append(ret, Range{r.Start, end})
+			r.Start = end
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package httpfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDownloadServer(t *testing.T, drops int) (*flakyServer, string, *FS) {
+	s, hs, fs := newFlakyServer(t, drops)
+	content := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(content)
+	s.set(string(content), `"v1"`)
+	s.dropAfter = 1000
+	return s, hs.URL, fs
+}
+
+func tempDest(t *testing.T) *os.File {
+	f, err := os.Create(filepath.Join(t.TempDir(), "dest"))
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func readDest(t *testing.T, f *os.File) []byte {
+	b, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	return b
+}
+
+func TestDownload(t *testing.T) {
+	// Some chunks fail part way, and are resumed.
+	s, url, fs := newDownloadServer(t, 3)
+	dest := tempDest(t)
+	var lastDone, lastTotal int64
+	completed, validator, err := fs.Download(context.Background(), url, dest, DownloadOpts{
+		ChunkSize: 64 << 10,
+		Workers:   3,
+		Progress: func(done, total int64) {
+			assert.GreaterOrEqual(t, done, lastDone)
+			lastDone, lastTotal = done, total
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{0, 1 << 20}}, completed)
+	assert.Equal(t, `"v1"`, validator)
+	assert.EqualValues(t, 1<<20, lastDone)
+	assert.EqualValues(t, 1<<20, lastTotal)
+	assert.True(t, bytes.Equal(s.content, readDest(t, dest)))
+	assert.Equal(t, 16+3, s.gets)
+}
+
+func TestDownloadResume(t *testing.T) {
+	s, url, fs := newDownloadServer(t, 0)
+	dest := tempDest(t)
+	_, err := dest.WriteAt(s.content[:1<<19], 0)
+	require.NoError(t, err)
+	completed, _, err := fs.Download(context.Background(), url, dest, DownloadOpts{
+		ChunkSize: 64 << 10,
+		Completed: []Range{{0, 1 << 19}},
+		Validator: `"v1"`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{0, 1 << 20}}, completed)
+	assert.True(t, bytes.Equal(s.content, readDest(t, dest)))
+	assert.Equal(t, 8, s.gets)
+}
+
+func TestDownloadChanged(t *testing.T) {
+	s, url, fs := newDownloadServer(t, 0)
+	dest := tempDest(t)
+	_, _, err := fs.Download(context.Background(), url, dest, DownloadOpts{
+		ChunkSize: 64 << 10,
+		Workers:   1,
+		Progress: func(done, total int64) {
+			if done == 64<<10 {
+				s.set(string(s.content), `"v2"`)
+			}
+		},
+	})
+	var ce *ChangedError
+	require.True(t, errors.As(err, &ce), "%v", err)
+}
+
+func TestDownloadResumeChanged(t *testing.T) {
+	s, url, fs := newDownloadServer(t, 0)
+	s.set(string(s.content), `"v2"`)
+	completed, validator, err := fs.Download(context.Background(), url, tempDest(t), DownloadOpts{
+		Completed: []Range{{0, 1 << 19}},
+		Validator: `"v1"`,
+	})
+	var ce *ChangedError
+	require.True(t, errors.As(err, &ce), "%v", err)
+	assert.Equal(t, `"v1"`, ce.Validator)
+	assert.Equal(t, []Range{{0, 1 << 19}}, completed)
+	assert.Equal(t, `"v1"`, validator)
+	assert.Zero(t, s.gets)
+}
+
+// Progress on a chunk resets its retries.
+func TestDownloadRetriesAfterProgress(t *testing.T) {
+	s, url, fs := newDownloadServer(t, 2)
+	fs.ReadRetries = 1
+	_, _, err := fs.Download(context.Background(), url, tempDest(t), DownloadOpts{
+		Workers: 1,
+		Progress: func(done, total int64) {
+			// The next drop is before any of the body.
+			s.mu.Lock()
+			s.dropAfter = 0
+			s.mu.Unlock()
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, s.gets)
+}
+
+func TestDownloadRangesNotSupported(t *testing.T) {
+	s, url, fs := newDownloadServer(t, 0)
+	s.ignoreRanges = true
+	_, _, err := fs.Download(context.Background(), url, tempDest(t), DownloadOpts{})
+	assert.Equal(t, ErrRangeNotSupported, err)
+}
+
+func TestRangeSets(t *testing.T) {
+	merged := mergeRanges([]Range{{10, 20}, {0, 5}, {5, 8}, {15, 30}, {40, 40}})
+	assert.Equal(t, []Range{{0, 8}, {10, 30}}, merged)
+	assert.Equal(t, []Range{{8, 10}, {30, 35}}, missingRanges(merged, 35))
+	assert.Equal(t, []Range{{8, 10}}, missingRanges(merged, 25))
+	assert.Equal(t, []Range{{0, 3}, {3, 5}, {7, 9}}, splitRanges([]Range{{0, 5}, {7, 9}}, 3))
+}
@@ -1,13 +1,13 @@
 package httpfile
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/anacrolix/missinggo/httptoo"
 
@@ -22,6 +22,12 @@ type File struct {
 	url    string
 	flags  int
 	fs     *FS
+	// Writes are part of a resumable upload.
+	resumable bool
+	// Identify the version of the resource first read from, so later requests can check it hasn't
+	// changed.
+	etag         string
+	lastModified string
 }
 
 func (me *File) headLength() (err error) {
@@ -35,13 +41,19 @@ func (me *File) headLength() (err error) {
 	return
 }
 
-func (me *File) prepareReader() (err error) {
-	if me.r != nil && me.off != me.rOff {
+func (me *File) closeReader() {
+	if me.r != nil {
 		me.r.Close()
 		me.r = nil
 	}
+}
+
+func (me *File) prepareReader() (retry bool, err error) {
+	if me.r != nil && me.off != me.rOff {
+		me.closeReader()
+	}
 	if me.r != nil {
-		return nil
+		return
 	}
 	if me.flags&missinggo.O_ACCMODE == os.O_WRONLY {
 		err = errors.New("read flags missing")
@@ -53,9 +65,13 @@ func (me *File) prepareReader() (err error) {
 	}
 	if me.off != 0 {
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", me.off))
+		if v := me.validator(); v != "" {
+			req.Header.Set("If-Range", v)
+		}
 	}
 	resp, err := me.fs.Client.Do(req)
 	if err != nil {
+		retry = true
 		return
 	}
 	switch resp.StatusCode {
@@ -69,7 +85,12 @@ func (me *File) prepareReader() (err error) {
 		me.length = cr.Length
 	case http.StatusOK:
 		if me.off != 0 {
-			err = errors.New("bad response")
+			// If-Range didn't match, or the server ignored the Range.
+			if me.validator() != "" && !me.checkValidator(resp) {
+				err = me.changedError()
+			} else {
+				err = ErrRangeNotSupported
+			}
 			resp.Body.Close()
 			return
 		}
@@ -88,6 +109,12 @@ func (me *File) prepareReader() (err error) {
 		return
 	default:
 		err = errors.New(resp.Status)
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		resp.Body.Close()
+		return
+	}
+	if !me.checkValidator(resp) {
+		err = me.changedError()
 		resp.Body.Close()
 		return
 	}
@@ -96,15 +123,35 @@ func (me *File) prepareReader() (err error) {
 	return
 }
 
+// Reads are resumed from the current offset if the connection fails, as long as the resource
+// hasn't changed since it was first read. If it has, a *ChangedError is returned.
 func (me *File) Read(b []byte) (n int, err error) {
-	err = me.prepareReader()
-	if err != nil {
-		return
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = me.prepareReader()
+		if err == nil {
+			n, err = me.r.Read(b)
+			me.off += int64(n)
+			me.rOff += int64(n)
+			if err == io.EOF && me.length >= 0 && me.off < me.length {
+				err = io.ErrUnexpectedEOF
+			}
+			if err == nil || err == io.EOF {
+				return
+			}
+			// The body failed part way. Start a new one from where it left off.
+			me.closeReader()
+			if n != 0 {
+				err = nil
+				return
+			}
+			retry = true
+		}
+		if !retry || attempt >= me.fs.readRetries() {
+			return
+		}
+		time.Sleep(me.fs.retryDelay(attempt))
 	}
-	n, err = me.r.Read(b)
-	me.off += int64(n)
-	me.rOff += int64(n)
-	return
 }
 
 func (me *File) Seek(offset int64, whence int) (ret int64, err error) {
@@ -132,37 +179,48 @@ func (me *File) Seek(offset int64, whence int) (ret int64, err error) {
 	return
 }
 
+// Writes are sent with PATCH at the current offset. For a File from OpenResumable, a write that
+// fails is retried from however much of it the server received.
 func (me *File) Write(b []byte) (n int, err error) {
 	if me.flags&(os.O_WRONLY|os.O_RDWR) == 0 || me.flags&os.O_CREATE == 0 {
 		err = errors.New("cannot write without write and create flags")
 		return
 	}
-	req, err := http.NewRequest("PATCH", me.url, bytes.NewReader(b))
-	if err != nil {
-		return
-	}
-	req.Header.Set("Content-Range", fmt.Sprintf("bytes=%d-", me.off))
-	req.ContentLength = int64(len(b))
-	resp, err := me.fs.Client.Do(req)
-	if err != nil {
+	if len(b) == 0 {
 		return
 	}
-	resp.Body.Close()
-	if resp.StatusCode != http.StatusPartialContent {
-		err = errors.New(resp.Status)
-		return
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = me.sendWrite(b[n:])
+		if err == nil {
+			me.off += int64(len(b) - n)
+			n = len(b)
+			return
+		}
+		if !me.resumable || !retry || attempt >= me.fs.readRetries() {
+			return
+		}
+		time.Sleep(me.fs.retryDelay(attempt))
+		off, offErr := me.fs.UploadOffset(me.url)
+		if offErr != nil || off < me.off || off > me.off+int64(len(b)-n) {
+			// Can't tell where to resume from.
+			return
+		}
+		n += int(off - me.off)
+		me.off = off
+		if n == len(b) {
+			// It all arrived, and only the response was lost.
+			err = nil
+			return
+		}
 	}
-	n = len(b)
-	me.off += int64(n)
-	return
 }
 
 func (me *File) Close() error {
 	me.url = ""
 	me.length = -1
-	if me.r != nil {
-		me.r.Close()
-		me.r = nil
-	}
+	me.etag = ""
+	me.lastModified = ""
+	me.closeReader()
 	return nil
 }
@@ -0,0 +1,131 @@
+package httpfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Serves content that can be replaced, and drops the connection part way through the next drops
+// responses.
+type flakyServer struct {
+	mu      sync.Mutex
+	content []byte
+	etag    string
+	drops   int
+	// Bytes of the body written before a dropped response is aborted.
+	dropAfter int
+	gets      int
+	// Serve the whole of the content whatever the Range.
+	ignoreRanges bool
+}
+
+func (me *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	me.mu.Lock()
+	content, etag, dropAfter, ignoreRanges := me.content, me.etag, me.dropAfter, me.ignoreRanges
+	drop := r.Method == "GET" && me.drops > 0
+	if drop {
+		me.drops--
+	}
+	if r.Method == "GET" {
+		me.gets++
+	}
+	me.mu.Unlock()
+	w.Header().Set("ETag", etag)
+	if drop {
+		w = &abortingWriter{ResponseWriter: w, left: dropAfter}
+	}
+	if ignoreRanges {
+		r.Header.Del("Range")
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+// Aborts the response after writing some of the body.
+type abortingWriter struct {
+	http.ResponseWriter
+	left int
+}
+
+func (me *abortingWriter) Write(b []byte) (int, error) {
+	if len(b) > me.left {
+		me.ResponseWriter.Write(b[:me.left])
+		me.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	me.left -= len(b)
+	return me.ResponseWriter.Write(b)
+}
+
+func (me *flakyServer) set(content, etag string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.content = []byte(content)
+	me.etag = etag
+}
+
+func newFlakyServer(t *testing.T, drops int) (*flakyServer, *httptest.Server, *FS) {
+	s := &flakyServer{content: []byte("hello, world!"), etag: `"v1"`, drops: drops, dropAfter: 3}
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	return s, hs, &FS{Client: hs.Client(), RetryBackoff: time.Millisecond}
+}
+
+func TestReadResumes(t *testing.T) {
+	s, hs, fs := newFlakyServer(t, 2)
+	f, err := fs.Open(hs.URL, os.O_RDONLY)
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(b))
+	assert.Equal(t, 3, s.gets)
+}
+
+func TestReadRetriesExhausted(t *testing.T) {
+	s, hs, fs := newFlakyServer(t, 10)
+	fs.ReadRetries = 2
+	// Resuming after progress doesn't count as a retry, so fail before any.
+	s.dropAfter = 0
+	f, err := fs.Open(hs.URL, os.O_RDONLY)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = io.ReadAll(f)
+	assert.Error(t, err)
+	assert.Equal(t, 3, s.gets)
+}
+
+func TestReadDetectsChange(t *testing.T) {
+	s, hs, fs := newFlakyServer(t, 1)
+	f, err := fs.Open(hs.URL, os.O_RDONLY)
+	require.NoError(t, err)
+	defer f.Close()
+	// Stop within the part of the body before the drop.
+	b := make([]byte, 2)
+	_, err = io.ReadFull(f, b)
+	require.NoError(t, err)
+	s.set("goodbye, world!", `"v2"`)
+	_, err = io.ReadAll(f)
+	var ce *ChangedError
+	require.True(t, errors.As(err, &ce), "%v", err)
+	assert.Equal(t, `"v1"`, ce.Validator)
+}
+
+func TestReadRangesNotSupported(t *testing.T) {
+	s, hs, fs := newFlakyServer(t, 1)
+	s.ignoreRanges = true
+	f, err := fs.Open(hs.URL, os.O_RDONLY)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = io.ReadAll(f)
+	assert.Equal(t, ErrRangeNotSupported, err)
+}
@@ -5,10 +5,45 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"time"
+
+	"github.com/anacrolix/missinggo/v2"
 )
 
 type FS struct {
 	Client *http.Client
+	// How many times a File read that fails part way is resumed before the error is returned. Zero
+	// uses DefaultReadRetries, and a negative value disables resuming.
+	ReadRetries int
+	// The delay before the first resume, which doubles with each further attempt. Zero uses
+	// DefaultRetryBackoff.
+	RetryBackoff time.Duration
+}
+
+const (
+	DefaultReadRetries  = 5
+	DefaultRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff     = 10 * time.Second
+)
+
+func (fs *FS) readRetries() int {
+	if fs.ReadRetries == 0 {
+		return DefaultReadRetries
+	}
+	return fs.ReadRetries
+}
+
+// Returns a jittered delay before the given retry, counting from zero.
+func (fs *FS) retryDelay(attempt int) time.Duration {
+	d := fs.RetryBackoff
+	if d == 0 {
+		d = DefaultRetryBackoff
+	}
+	for ; attempt > 0 && d < maxRetryBackoff; attempt-- {
+		d *= 2
+	}
+	d = min(d, maxRetryBackoff)
+	return missinggo.JitterDuration(d, d/2)
 }
 
 func (fs *FS) Delete(urlStr string) (err error) {
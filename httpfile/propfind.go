@@ -0,0 +1,158 @@
+package httpfile
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Describes a resource listed by PROPFIND.
+type Entry struct {
+	// The unescaped path of the resource, as given by the server.
+	Href string
+	// The last element of the path.
+	Name    string
+	Size    int64
+	ModTime time.Time
+	ETag    string
+	IsDir   bool
+}
+
+// The properties requested. Servers may return others, which are ignored.
+const propfindBody = xml.Header + `<propfind xmlns="DAV:"><prop>` +
+	`<resourcetype/><getcontentlength/><getlastmodified/><getetag/>` +
+	`</prop></propfind>`
+
+// Lists the resource at url with PROPFIND. A depth of 0 describes only the resource itself, and a
+// depth of 1 includes the members of a collection too.
+func (fs *FS) Propfind(url string, depth int) (ret []Entry, err error) {
+	if depth != 0 && depth != 1 {
+		err = fmt.Errorf("unsupported depth %d", depth)
+		return
+	}
+	req, err := http.NewRequest("PROPFIND", url, strings.NewReader(propfindBody))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Depth", strconv.Itoa(depth))
+	req.Header.Set("Content-Type", `application/xml; charset="utf-8"`)
+	resp, err := fs.Client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusMultiStatus:
+	case http.StatusNotFound:
+		err = ErrNotFound
+		return
+	default:
+		err = fmt.Errorf("response: %s", resp.Status)
+		return
+	}
+	var ms multistatus
+	err = xml.NewDecoder(resp.Body).Decode(&ms)
+	if err != nil {
+		err = fmt.Errorf("decoding multistatus: %w", err)
+		return
+	}
+	for _, r := range ms.Responses {
+		var e Entry
+		e, err = r.entry()
+		if err != nil {
+			return
+		}
+		ret = append(ret, e)
+	}
+	return
+}
+
+// Returns the members of the collection at url.
+func (fs *FS) ReadDir(urlStr string) (ret []Entry, err error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return
+	}
+	es, err := fs.Propfind(urlStr, 1)
+	if err != nil {
+		return
+	}
+	self := strings.TrimSuffix(u.Path, "/")
+	for _, e := range es {
+		if strings.TrimSuffix(e.Href, "/") == self {
+			if !e.IsDir {
+				return nil, errors.New("not a collection")
+			}
+			continue
+		}
+		ret = append(ret, e)
+	}
+	return
+}
+
+type multistatus struct {
+	XMLName   xml.Name       `xml:"DAV: multistatus"`
+	Responses []propResponse `xml:"DAV: response"`
+}
+
+type propResponse struct {
+	Href      string     `xml:"DAV: href"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+type prop struct {
+	ResourceType  resourceType `xml:"DAV: resourcetype"`
+	ContentLength string       `xml:"DAV: getcontentlength,omitempty"`
+	LastModified  string       `xml:"DAV: getlastmodified,omitempty"`
+	ETag          string       `xml:"DAV: getetag,omitempty"`
+}
+
+type resourceType struct {
+	Collection *struct{} `xml:"DAV: collection"`
+}
+
+func (me propResponse) entry() (e Entry, err error) {
+	u, err := url.Parse(me.Href)
+	if err != nil {
+		return
+	}
+	e.Href = u.Path
+	e.Name = path.Base(strings.TrimSuffix(u.Path, "/"))
+	for _, ps := range me.Propstats {
+		// Properties the server doesn't have are listed with a failure status.
+		if f := strings.Fields(ps.Status); len(f) < 2 || f[1] != "200" {
+			continue
+		}
+		p := ps.Prop
+		if p.ResourceType.Collection != nil {
+			e.IsDir = true
+		}
+		if p.ContentLength != "" {
+			e.Size, err = strconv.ParseInt(p.ContentLength, 10, 64)
+			if err != nil {
+				return
+			}
+		}
+		if p.LastModified != "" {
+			e.ModTime, err = http.ParseTime(p.LastModified)
+			if err != nil {
+				return
+			}
+		}
+		if p.ETag != "" {
+			e.ETag = p.ETag
+		}
+	}
+	return
+}
@@ -0,0 +1,93 @@
+package httpfile
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+)
+
+// Answers PROPFIND requests with Depth 0 or 1 for files in root, and passes other requests to next.
+// The properties listed are those FS.Propfind requests.
+func PropfindHandler(root http.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		servePropfind(w, r, root)
+	})
+}
+
+func servePropfind(w http.ResponseWriter, r *http.Request, root http.FileSystem) {
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		http.Error(w, "Depth must be 0 or 1", http.StatusForbidden)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	f, err := root.Open(name)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("error opening %q: %v", name, err)
+		http.Error(w, "error opening file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		log.Printf("error statting %q: %v", name, err)
+		http.Error(w, "error statting file", http.StatusInternalServerError)
+		return
+	}
+	var ms multistatus
+	ms.add(name, fi)
+	if depth == "1" && fi.IsDir() {
+		fis, err := f.Readdir(-1)
+		if err != nil {
+			log.Printf("error reading directory %q: %v", name, err)
+			http.Error(w, "error reading directory", http.StatusInternalServerError)
+			return
+		}
+		sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+		for _, fi := range fis {
+			ms.add(path.Join(name, fi.Name()), fi)
+		}
+	}
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	err = xml.NewEncoder(w).Encode(ms)
+	if err != nil {
+		log.Printf("error writing multistatus: %v", err)
+	}
+}
+
+func (me *multistatus) add(name string, fi os.FileInfo) {
+	var p prop
+	if fi.IsDir() {
+		p.ResourceType.Collection = &struct{}{}
+		if name != "/" {
+			name += "/"
+		}
+	} else {
+		p.ContentLength = fmt.Sprint(fi.Size())
+		p.ETag = fmt.Sprintf(`"%x-%x"`, fi.Size(), fi.ModTime().UnixNano())
+	}
+	p.LastModified = fi.ModTime().UTC().Format(http.TimeFormat)
+	me.Responses = append(me.Responses, propResponse{
+		Href: (&url.URL{Path: name}).EscapedPath(),
+		Propstats: []propstat{{
+			Prop:   p,
+			Status: "HTTP/1.1 200 OK",
+		}},
+	})
+}
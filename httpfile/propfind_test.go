@@ -0,0 +1,72 @@
+package httpfile
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPropfindServer(t *testing.T) (string, *FS) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a file"), []byte("hello"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("hi"), 0o644))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a file"), mtime, mtime))
+	root := http.Dir(dir)
+	hs := httptest.NewServer(PropfindHandler(root, http.FileServer(root)))
+	t.Cleanup(hs.Close)
+	return hs.URL, &FS{Client: hs.Client()}
+}
+
+func TestPropfind(t *testing.T) {
+	url, fs := newPropfindServer(t)
+	es, err := fs.Propfind(url+"/a%20file", 0)
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	e := es[0]
+	assert.Equal(t, "/a file", e.Href)
+	assert.Equal(t, "a file", e.Name)
+	assert.EqualValues(t, 5, e.Size)
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), e.ModTime)
+	assert.NotEmpty(t, e.ETag)
+	assert.False(t, e.IsDir)
+	es, err = fs.Propfind(url+"/", 1)
+	require.NoError(t, err)
+	assert.Len(t, es, 3)
+	es, err = fs.ReadDir(url)
+	require.NoError(t, err)
+	require.Len(t, es, 2)
+	assert.Equal(t, "a file", es[0].Name)
+	assert.Equal(t, "sub", es[1].Name)
+	assert.True(t, es[1].IsDir)
+	es, err = fs.ReadDir(url + "/sub/")
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	assert.Equal(t, "b", es[0].Name)
+	assert.EqualValues(t, 2, es[0].Size)
+	_, err = fs.ReadDir(url + "/a%20file")
+	assert.Error(t, err)
+	_, err = fs.Propfind(url+"/missing", 0)
+	assert.ErrorIs(t, err, ErrNotFound)
+	// Other methods still reach the file server.
+	l, err := fs.GetLength(url + "/a%20file")
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, l)
+}
+
+func TestPropfindInfiniteDepth(t *testing.T) {
+	url, fs := newPropfindServer(t)
+	req, err := http.NewRequest("PROPFIND", url, nil)
+	require.NoError(t, err)
+	resp, err := fs.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
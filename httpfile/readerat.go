@@ -0,0 +1,153 @@
+package httpfile
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/anacrolix/missinggo/v2/cache"
+)
+
+const (
+	DefaultBlockSize  = 256 << 10
+	DefaultBlockCache = 16 << 20
+	DefaultReadahead  = 4
+)
+
+type ReaderAtOpts struct {
+	// The size of the ranges requested. Defaults to DefaultBlockSize.
+	BlockSize int64
+	// Bytes of recently used blocks to keep. Defaults to DefaultBlockCache.
+	CacheSize int64
+	// Blocks fetched ahead of sequential reads. Defaults to DefaultReadahead, and a negative value
+	// disables readahead.
+	Readahead int
+}
+
+// Gives random access to a remote resource. It's fetched in fixed size blocks, which are cached,
+// and concurrent reads of a block share a request. Reads of consecutive blocks fetch the blocks
+// after them in the background. If the resource changes, reads return a *ChangedError.
+type ReaderAt struct {
+	fs        *FS
+	url       string
+	validator string
+	length    int64
+	blockSize int64
+	readahead int
+	blocks    *cache.Loading[int64, []byte]
+
+	mu sync.Mutex
+	// The last block of the previous read.
+	lastBlock int64
+}
+
+var _ io.ReaderAt = (*ReaderAt)(nil)
+
+func (fs *FS) NewReaderAt(url string, opts ReaderAtOpts) (ret *ReaderAt, err error) {
+	length, validator, err := fs.headValidator(context.Background(), url)
+	if err != nil {
+		return
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = DefaultBlockSize
+	}
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = DefaultBlockCache
+	}
+	if opts.Readahead == 0 {
+		opts.Readahead = DefaultReadahead
+	}
+	ret = &ReaderAt{
+		fs:        fs,
+		url:       url,
+		validator: validator,
+		length:    length,
+		blockSize: opts.BlockSize,
+		readahead: max(opts.Readahead, 0),
+		lastBlock: -2,
+	}
+	ret.blocks = cache.NewLoading(cache.LoadingOpts[int64, []byte]{
+		Load:     ret.loadBlock,
+		Capacity: opts.CacheSize,
+		Size: func(_ int64, b []byte) int64 {
+			return int64(len(b))
+		},
+	})
+	return
+}
+
+// The length of the resource when the ReaderAt was created.
+func (me *ReaderAt) Size() int64 {
+	return me.length
+}
+
+func (me *ReaderAt) numBlocks() int64 {
+	return (me.length + me.blockSize - 1) / me.blockSize
+}
+
+func (me *ReaderAt) loadBlock(ctx context.Context, i int64) ([]byte, error) {
+	r := Range{i * me.blockSize, min((i+1)*me.blockSize, me.length)}
+	b := make([]byte, r.End-r.Start)
+	d := &download{
+		fs:        me.fs,
+		url:       me.url,
+		dest:      &blockWriter{b, r.Start},
+		validator: me.validator,
+		total:     me.length,
+	}
+	err := d.fetchChunk(ctx, r)
+	return b, err
+}
+
+func (me *ReaderAt) ReadAt(b []byte, off int64) (n int, err error) {
+	return me.ReadAtContext(context.Background(), b, off)
+}
+
+// Like ReadAt, but returns early if ctx is done.
+func (me *ReaderAt) ReadAtContext(ctx context.Context, b []byte, off int64) (n int, err error) {
+	if off >= me.length {
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		return
+	}
+	end := min(off+int64(len(b)), me.length)
+	first, last := off/me.blockSize, (end-1)/me.blockSize
+	me.prefetch(ctx, first, last)
+	for i := first; i <= last; i++ {
+		var block []byte
+		block, err = me.blocks.Get(ctx, i)
+		if err != nil {
+			return
+		}
+		n += copy(b[n:], block[off+int64(n)-i*me.blockSize:])
+	}
+	if n < len(b) {
+		err = io.EOF
+	}
+	return
+}
+
+// Fetches the blocks after a read in the background, if it follows on from the previous one.
+func (me *ReaderAt) prefetch(ctx context.Context, first, last int64) {
+	me.mu.Lock()
+	sequential := first == me.lastBlock || first == me.lastBlock+1
+	me.lastBlock = last
+	me.mu.Unlock()
+	if !sequential {
+		return
+	}
+	for i := last + 1; i <= last+int64(me.readahead) && i < me.numBlocks(); i++ {
+		me.blocks.Prefetch(ctx, i)
+	}
+}
+
+// Writes a block's worth of the resource into a buffer.
+type blockWriter struct {
+	b   []byte
+	off int64
+}
+
+func (me *blockWriter) WriteAt(b []byte, off int64) (int, error) {
+	return copy(me.b[off-me.off:], b), nil
+}
@@ -0,0 +1,104 @@
+package httpfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderAt(t *testing.T) {
+	s, url, fs := newDownloadServer(t, 0)
+	ra, err := fs.NewReaderAt(url, ReaderAtOpts{
+		BlockSize: 64 << 10,
+		Readahead: -1,
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1<<20, ra.Size())
+	// Reads that span blocks, and reads within blocks that are already cached.
+	b := make([]byte, 100)
+	for _, off := range []int64{(64 << 10) - 50, 10, (128 << 10) - 1} {
+		n, err := ra.ReadAt(b, off)
+		require.NoError(t, err)
+		assert.Equal(t, 100, n)
+		assert.Equal(t, s.content[off:off+100], b)
+	}
+	assert.Equal(t, 3, s.gets)
+	n, err := ra.ReadAt(b, (1<<20)-40)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 40, n)
+	assert.Equal(t, s.content[(1<<20)-40:], b[:40])
+	n, err = ra.ReadAt(b, 1<<20)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestReaderAtCoalescesReads(t *testing.T) {
+	s, url, fs := newDownloadServer(t, 0)
+	ra, err := fs.NewReaderAt(url, ReaderAtOpts{BlockSize: 64 << 10, Readahead: -1})
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := make([]byte, 10)
+			_, err := ra.ReadAt(b, int64(i*1000))
+			assert.NoError(t, err)
+			assert.Equal(t, s.content[i*1000:i*1000+10], b)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, s.gets)
+}
+
+func TestReaderAtReadahead(t *testing.T) {
+	s, url, fs := newDownloadServer(t, 0)
+	ra, err := fs.NewReaderAt(url, ReaderAtOpts{BlockSize: 64 << 10, Readahead: 2})
+	require.NoError(t, err)
+	b, err := io.ReadAll(io.NewSectionReader(ra, 0, ra.Size()))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(s.content, b))
+	// Each block is fetched once, whether it was read ahead or not.
+	assert.Equal(t, 16, s.gets)
+}
+
+func TestReaderAtChanged(t *testing.T) {
+	s, url, fs := newDownloadServer(t, 0)
+	ra, err := fs.NewReaderAt(url, ReaderAtOpts{BlockSize: 64 << 10, Readahead: -1})
+	require.NoError(t, err)
+	s.set(string(s.content), `"v2"`)
+	_, err = ra.ReadAt(make([]byte, 10), 0)
+	var ce *ChangedError
+	assert.True(t, errors.As(err, &ce), "%v", err)
+}
+
+// Opening a zip only reads the parts of the archive it needs.
+func TestReaderAtZip(t *testing.T) {
+	s, url, fs := newDownloadServer(t, 0)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "padding", Method: zip.Store})
+	require.NoError(t, err)
+	w.Write(s.content)
+	w, err = zw.Create("hello.txt")
+	require.NoError(t, err)
+	io.WriteString(w, "hello, world")
+	require.NoError(t, zw.Close())
+	s.set(buf.String(), `"zip"`)
+	ra, err := fs.NewReaderAt(url, ReaderAtOpts{BlockSize: 16 << 10, Readahead: -1})
+	require.NoError(t, err)
+	zr, err := zip.NewReader(ra, ra.Size())
+	require.NoError(t, err)
+	rc, err := zr.Open("hello.txt")
+	require.NoError(t, err)
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(b))
+	assert.LessOrEqual(t, s.gets, 3)
+}
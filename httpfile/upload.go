@@ -0,0 +1,116 @@
+package httpfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Reports how much of a resource from the start a server has, for resuming uploads. Requests that
+// send it are part of a resumable upload, and give the offset they continue from.
+const UploadOffsetHeader = "Upload-Offset"
+
+var errResumeUnsupported = errors.New("server doesn't support resuming uploads")
+
+// Returns where an upload to url should resume from. It's 0 if the resource doesn't exist.
+func (fs *FS) UploadOffset(url string) (off int64, err error) {
+	resp, err := fs.Client.Head(url)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return 0, nil
+	default:
+		err = errors.New(resp.Status)
+		return
+	}
+	h := resp.Header.Get(UploadOffsetHeader)
+	if h == "" {
+		err = errResumeUnsupported
+		return
+	}
+	return strconv.ParseInt(h, 10, 64)
+}
+
+// Opens url for a resumable upload, positioned at the end of what the server already has. Writes
+// must be sequential, and each tells the server where it continues from, so the server can keep
+// what it received if the write fails.
+func (fs *FS) OpenResumable(url string) (ret *File, err error) {
+	off, err := fs.UploadOffset(url)
+	if err != nil {
+		return
+	}
+	ret = &File{
+		url:       url,
+		flags:     os.O_WRONLY | os.O_CREATE,
+		length:    -1,
+		fs:        fs,
+		off:       off,
+		resumable: true,
+	}
+	return
+}
+
+// Uploads size bytes from r to url, continuing from whatever the server already has. A url should
+// be used for only one upload, as what's there is assumed to be from r. The data is sent in chunks,
+// and each is resumed after failures as writes to a File from OpenResumable are.
+func (fs *FS) Upload(url string, r io.ReaderAt, size int64, chunkSize int64) (err error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	f, err := fs.OpenResumable(url)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if f.off > size {
+		return fmt.Errorf("server has %d bytes, more than the upload", f.off)
+	}
+	buf := make([]byte, min(chunkSize, size-f.off))
+	for f.off < size {
+		b := buf[:min(int64(len(buf)), size-f.off)]
+		_, err = r.ReadAt(b, f.off)
+		if err != nil {
+			return
+		}
+		_, err = f.Write(b)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Sends b at the File's offset.
+func (me *File) sendWrite(b []byte) (retry bool, err error) {
+	req, err := http.NewRequest("PATCH", me.url, bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", me.off, me.off+int64(len(b))-1))
+	if me.resumable {
+		req.Header.Set(UploadOffsetHeader, strconv.FormatInt(me.off, 10))
+	}
+	req.ContentLength = int64(len(b))
+	resp, err := me.fs.Client.Do(req)
+	if err != nil {
+		retry = true
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		err = errors.New(resp.Status)
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests ||
+			// The server has a different offset, perhaps from a previous attempt it was still
+			// handling.
+			resp.StatusCode == http.StatusConflict && resp.Header.Get(UploadOffsetHeader) != ""
+	}
+	return
+}
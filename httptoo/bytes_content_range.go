@@ -60,37 +60,41 @@ func parseUnitRanges(s string) (unit, ranges string) {
 	return
 }
 
-func parseFirstLast(s string) (first, last int64) {
-	ss := strings.SplitN(s, "-", 2)
-	first, err := strconv.ParseInt(ss[0], 10, 64)
-	if err != nil {
-		panic(err)
+func parseFirstLast(s string) (first, last int64, err error) {
+	firstStr, lastStr, ok := strings.Cut(s, "-")
+	if !ok {
+		err = fmt.Errorf("missing '-' in %q", s)
+		return
 	}
-	last, err = strconv.ParseInt(ss[1], 10, 64)
+	first, err = strconv.ParseInt(firstStr, 10, 64)
 	if err != nil {
-		panic(err)
+		return
 	}
+	last, err = strconv.ParseInt(lastStr, 10, 64)
 	return
 }
 
-func parseContentRange(s string) (ret BytesContentRange) {
-	ss := strings.SplitN(s, "/", 2)
-	firstLast := strings.TrimSpace(ss[0])
+func parseContentRange(s string) (ret BytesContentRange, err error) {
+	firstLast, il, ok := strings.Cut(s, "/")
+	if !ok {
+		err = fmt.Errorf("missing '/' in %q", s)
+		return
+	}
+	firstLast = strings.TrimSpace(firstLast)
 	if firstLast == "*" {
 		ret.First = -1
 		ret.Last = -1
 	} else {
-		ret.First, ret.Last = parseFirstLast(firstLast)
+		ret.First, ret.Last, err = parseFirstLast(firstLast)
+		if err != nil {
+			return
+		}
 	}
-	il := strings.TrimSpace(ss[1])
+	il = strings.TrimSpace(il)
 	if il == "*" {
 		ret.Length = -1
 	} else {
-		var err error
 		ret.Length, err = strconv.ParseInt(il, 10, 64)
-		if err != nil {
-			panic(err)
-		}
 	}
 	return
 }
@@ -100,7 +104,7 @@ func ParseBytesContentRange(s string) (ret BytesContentRange, ok bool) {
 	if unit != "bytes" {
 		return
 	}
-	ret = parseContentRange(ranges)
-	ok = true
+	ret, err := parseContentRange(ranges)
+	ok = err == nil
 	return
 }
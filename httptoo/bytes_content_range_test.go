@@ -18,6 +18,10 @@ func TestParseHTTPContentRange(t *testing.T) {
 		{" bytes=12-34/*", &BytesContentRange{12, 34, -1}},
 		{"  bytes 12-34/56", &BytesContentRange{12, 34, 56}},
 		{"  bytes=*/56", &BytesContentRange{-1, -1, 56}},
+		{"bytes 1-2", nil},
+		{"bytes x-2/3", nil},
+		{"bytes 1/3", nil},
+		{"bytes 1-2/x", nil},
 	} {
 		ret, ok := ParseBytesContentRange(_case.h)
 		assert.Equal(t, _case.cr != nil, ok)
@@ -94,6 +94,7 @@ type OSFile interface {
 	Stat() (os.FileInfo, error)
 	io.ReaderAt
 	io.WriterAt
+	Truncate(size int64) error
 	Wrapped
 }
 
@@ -10,6 +10,8 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/anacrolix/missinggo/v2/httpfile"
 )
 
 // Provides access to resources through a http.Client.
@@ -38,7 +40,10 @@ type httpInstance struct {
 	URL    *url.URL
 }
 
-var _ Instance = &httpInstance{}
+var (
+	_ Instance    = &httpInstance{}
+	_ DirInstance = &httpInstance{}
+)
 
 func mustNewRequest(method, urlStr string, body io.Reader) *http.Request {
 	req, err := http.NewRequest(method, urlStr, body)
@@ -164,6 +169,19 @@ func (me *httpInstance) Delete() (err error) {
 	return
 }
 
+// Lists the members of the collection at the URL with PROPFIND.
+func (me *httpInstance) Readdirnames() (names []string, err error) {
+	fs := httpfile.FS{Client: me.Client}
+	es, err := fs.ReadDir(me.URL.String())
+	if err != nil {
+		return
+	}
+	for _, e := range es {
+		names = append(names, e.Name)
+	}
+	return
+}
+
 type httpFileInfo struct {
 	lastModified  time.Time
 	contentLength int64
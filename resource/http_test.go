@@ -0,0 +1,28 @@
+package resource
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/missinggo/v2/httpfile"
+)
+
+func TestHTTPInstanceReaddirnames(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), nil, 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "b"), 0o755))
+	root := http.Dir(dir)
+	hs := httptest.NewServer(httpfile.PropfindHandler(root, http.FileServer(root)))
+	defer hs.Close()
+	i, err := (&HTTPProvider{}).NewInstance(hs.URL + "/")
+	require.NoError(t, err)
+	names, err := i.(DirInstance).Readdirnames()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, names)
+}
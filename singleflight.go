@@ -1,6 +1,9 @@
 package missinggo
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 type ongoing struct {
 	// Waiters can proceed, someone finished the task.
@@ -48,3 +51,71 @@ func (me *SingleFlight) Unlock(id string) {
 	}
 	me.mu.Unlock()
 }
+
+// Shares the result of concurrent calls for the same key. Unlike SingleFlight, waiters get the
+// result of the call, and can give up waiting.
+type SingleFlightGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*singleFlightCall[V]
+}
+
+type singleFlightCall[V any] struct {
+	done    chan struct{}
+	v       V
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Runs fn for k if there isn't already a call for k in progress, and returns its result. If ctx is
+// done first, this caller stops waiting and gets ctx's error. Once all callers have stopped
+// waiting, the context passed to fn is canceled, and the next caller starts a new call. fn is given
+// the first caller's context values.
+func (me *SingleFlightGroup[K, V]) Do(ctx context.Context, k K, fn func(context.Context) (V, error)) (v V, err error) {
+	me.mu.Lock()
+	c, ok := me.calls[k]
+	if !ok {
+		fnCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &singleFlightCall[V]{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		if me.calls == nil {
+			me.calls = make(map[K]*singleFlightCall[V])
+		}
+		me.calls[k] = c
+		go me.run(fnCtx, k, c, fn)
+	}
+	c.waiters++
+	me.mu.Unlock()
+	select {
+	case <-c.done:
+		return c.v, c.err
+	case <-ctx.Done():
+	}
+	me.mu.Lock()
+	c.waiters--
+	if c.waiters == 0 {
+		c.cancel()
+		me.forget(k, c)
+	}
+	me.mu.Unlock()
+	err = ctx.Err()
+	return
+}
+
+func (me *SingleFlightGroup[K, V]) run(ctx context.Context, k K, c *singleFlightCall[V], fn func(context.Context) (V, error)) {
+	defer c.cancel()
+	c.v, c.err = fn(ctx)
+	me.mu.Lock()
+	me.forget(k, c)
+	me.mu.Unlock()
+	close(c.done)
+}
+
+// Removes the call for k, if it's still c.
+func (me *SingleFlightGroup[K, V]) forget(k K, c *singleFlightCall[V]) {
+	if me.calls[k] == c {
+		delete(me.calls, k)
+	}
+}
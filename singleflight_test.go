@@ -0,0 +1,95 @@
+package missinggo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleFlightGroupSharesResult(t *testing.T) {
+	var (
+		g       SingleFlightGroup[int, string]
+		calls   atomic.Int32
+		started = make(chan struct{})
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	fn := func(context.Context) (string, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return "done", nil
+	}
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.Do(context.Background(), 1, fn)
+			assert.NoError(t, err)
+			assert.Equal(t, "done", v)
+		}()
+	}
+	<-started
+	close(release)
+	wg.Wait()
+	// Late arrivals may have started their own call after the first finished.
+	assert.GreaterOrEqual(t, calls.Load(), int32(1))
+	g.mu.Lock()
+	assert.Empty(t, g.calls)
+	g.mu.Unlock()
+}
+
+func TestSingleFlightGroupWaiterCanceled(t *testing.T) {
+	var g SingleFlightGroup[string, int]
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		close(started)
+		<-release
+		return 42, ctx.Err()
+	}
+	result := make(chan error)
+	go func() {
+		v, err := g.Do(context.Background(), "a", fn)
+		assert.Equal(t, 42, v)
+		result <- err
+	}()
+	<-started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := g.Do(ctx, "a", fn)
+	assert.ErrorIs(t, err, context.Canceled)
+	// The other waiter keeps the call alive.
+	close(release)
+	assert.NoError(t, <-result)
+}
+
+func TestSingleFlightGroupAllWaitersCanceled(t *testing.T) {
+	var g SingleFlightGroup[string, int]
+	fnErr := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := g.Do(ctx, "a", func(ctx context.Context) (int, error) {
+			cancel()
+			<-ctx.Done()
+			fnErr <- ctx.Err()
+			return 0, ctx.Err()
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+	}()
+	<-done
+	require.ErrorIs(t, <-fnErr, context.Canceled)
+	// A new call is started for the next caller.
+	v, err := g.Do(context.Background(), "a", func(context.Context) (int, error) {
+		return 1, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+}